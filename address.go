@@ -0,0 +1,100 @@
+package dbus
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParseAddress splits a D-Bus server address, such as
+// "unix:path=/tmp/dbus-test", into its transport and its key-value
+// parameters. Parameter values are unescaped.
+func ParseAddress(address string) (transport string, params map[string]string, err error) {
+	i := strings.Index(address, ":")
+	if i < 0 {
+		return "", nil, fmt.Errorf("address %q has no transport", address)
+	}
+	transport = address[:i]
+	params = make(map[string]string)
+	for _, pair := range strings.Split(address[i+1:], ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return "", nil, fmt.Errorf("address %q: malformed parameter %q", address, pair)
+		}
+		value, err := unescapeAddressValue(kv[1])
+		if err != nil {
+			return "", nil, fmt.Errorf("address %q: %s", address, err)
+		}
+		params[kv[0]] = value
+	}
+	return transport, params, nil
+}
+
+// FormatAddress builds a D-Bus server address from its transport and
+// key-value parameters, escaping the values. Parameters are sorted by
+// key.
+func FormatAddress(transport string, params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	buf := []byte(transport + ":")
+	for i, key := range keys {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, key...)
+		buf = append(buf, '=')
+		buf = appendEscapedAddressValue(buf, params[key])
+	}
+	return string(buf)
+}
+
+// appendEscapedAddressValue appends s to buf, with bytes other than
+// [-0-9A-Za-z_/.\*] written as %XX.
+func appendEscapedAddressValue(buf []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '/', c == '.', c == '\\', c == '*':
+			buf = append(buf, c)
+		default:
+			buf = append(buf, '%', hex[c>>4], hex[c&15])
+		}
+	}
+	return buf
+}
+
+// unescapeAddressValue decodes the %XX escapes of an address value.
+func unescapeAddressValue(s string) (string, error) {
+	if strings.IndexByte(s, '%') < 0 {
+		return s, nil
+	}
+	buf := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			buf = append(buf, s[i])
+			continue
+		}
+		if i+2 >= len(s) || unhex(s[i+1]) < 0 || unhex(s[i+2]) < 0 {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		buf = append(buf, byte(unhex(s[i+1])<<4|unhex(s[i+2])))
+		i += 2
+	}
+	return string(buf), nil
+}
+
+func unhex(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c-'a') + 10
+	case 'A' <= c && c <= 'F':
+		return int(c-'A') + 10
+	}
+	return -1
+}
@@ -0,0 +1,62 @@
+package dbus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		address   string
+		transport string
+		params    map[string]string
+	}{
+		{"unix:path=/tmp/dbus-test", "unix", map[string]string{"path": "/tmp/dbus-test"}},
+		{"unix:path=/tmp/my%20bus%2c1", "unix", map[string]string{"path": "/tmp/my bus,1"}},
+		{"unix:path=/tmp/a=b,guid=42", "unix", map[string]string{"path": "/tmp/a=b", "guid": "42"}},
+		{"unix:abstract=/tmp/dbus-X", "unix", map[string]string{"abstract": "/tmp/dbus-X"}},
+	}
+	for _, test := range tests {
+		transport, params, err := ParseAddress(test.address)
+		if err != nil {
+			t.Errorf("%s: %s", test.address, err)
+			continue
+		}
+		if transport != test.transport || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%s: got %s %v, want %s %v", test.address,
+				transport, params, test.transport, test.params)
+		}
+	}
+
+	for _, address := range []string{
+		"/tmp/dbus-test",
+		"unix:path=/tmp/a%2",
+		"unix:path=%zz",
+		"unix:path",
+		"unix:=/tmp/dbus-test",
+		"unix:path=/tmp/dbus-test,",
+	} {
+		if _, _, err := ParseAddress(address); err == nil {
+			t.Errorf("%s: expected an error", address)
+		}
+	}
+}
+
+func TestFormatAddress(t *testing.T) {
+	params := map[string]string{
+		"path": "/tmp/my bus,1=2%",
+		"guid": "0123abcd",
+	}
+	address := FormatAddress("unix", params)
+	const want = "unix:guid=0123abcd,path=/tmp/my%20bus%2c1%3d2%25"
+	if address != want {
+		t.Errorf("got %q, want %q", address, want)
+	}
+	transport, got, err := ParseAddress(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport != "unix" || !reflect.DeepEqual(got, params) {
+		t.Errorf("round trip gives %s %v, want unix %v", transport, got, params)
+	}
+}
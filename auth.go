@@ -112,8 +112,43 @@ func min(l, r int) int {
 	return r
 }
 
+// readAuthLine reads a CRLF-terminated line of the authentication
+// protocol, which may arrive in several pieces, and strips the line
+// terminator.
+func readAuthLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("authentication line is not terminated by CRLF")
+	}
+	return line[:len(line)-2], nil
+}
+
+// negotiateUnixFd asks the server to pass file descriptors on a
+// unix socket. If it refuses, no file descriptors are expected.
+func (p *Connection) negotiateUnixFd(r *bufio.Reader) error {
+	if _, err := p.conn.Write([]byte("NEGOTIATE_UNIX_FD\r\n")); err != nil {
+		return err
+	}
+	mesg, err := readAuthLine(r)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(mesg, []byte("AGREE_UNIX_FD")) {
+		p.fds = nil
+	}
+	return nil
+}
+
 func (p *Connection) authenticate(mech Authenticator) error {
-	inStream := bufio.NewReader(p.conn)
+	// The reader is kept across mechanisms, so that no buffered
+	// data is lost.
+	if p.authReader == nil {
+		p.authReader = bufio.NewReader(p.conn)
+	}
+	inStream := p.authReader
 	msg := make([]byte, 0, 80)
 	msg = append(msg, "AUTH"...)
 	msg = append(msg, ' ')
@@ -127,7 +162,10 @@ func (p *Connection) authenticate(mech Authenticator) error {
 	}
 
 	for {
-		mesg, _, _ := inStream.ReadLine()
+		mesg, rerr := readAuthLine(inStream)
+		if rerr != nil {
+			return rerr
+		}
 
 		switch {
 		case bytes.HasPrefix(mesg, []byte("DATA")):
@@ -135,13 +173,24 @@ func (p *Connection) authenticate(mech Authenticator) error {
 			resp, err = mech.ProcessData(mesg[min(len("DATA "), len(mesg)):])
 			if err != nil {
 				p.conn.Write([]byte("CANCEL\r\n"))
+				continue
 			}
 			p.conn.Write(append(resp, "\r\n"...))
 
-		case bytes.HasPrefix(mesg, []byte("OK")),
-			bytes.HasPrefix(mesg, []byte("AGREE_UNIX_FD")):
-			p.conn.Write([]byte("BEGIN\r\n"))
-			return nil
+		case bytes.HasPrefix(mesg, []byte("OK")):
+			if p.fds != nil {
+				if err = p.negotiateUnixFd(inStream); err != nil {
+					return err
+				}
+			}
+			// The server must not send anything before BEGIN: messages
+			// are read from the connection from now on.
+			if inStream.Buffered() > 0 {
+				return errors.New("unexpected data after authentication")
+			}
+			p.authReader = nil
+			_, err = p.conn.Write([]byte("BEGIN\r\n"))
+			return err
 
 		case bytes.HasPrefix(mesg, []byte("REJECTED")):
 			// TODO: parse the supported auth mechanisms.
@@ -0,0 +1,90 @@
+package dbus
+
+import (
+	"bufio"
+	"net"
+	"testing"
+)
+
+func TestAuthenticateSlowServer(t *testing.T) {
+	cli, srv := net.Pipe()
+	defer cli.Close()
+	conn := &Connection{conn: cli}
+
+	lines := make(chan string, 4)
+	go func() {
+		defer close(lines)
+		defer srv.Close()
+		r := bufio.NewReader(srv)
+		for _, reply := range []string{"REJECTED EXTERNAL\r\n", "OK 0123456789abcdef\r\n"} {
+			line, err := readAuthLine(r)
+			if err != nil {
+				return
+			}
+			lines <- string(line)
+			// Send the reply one byte at a time.
+			for i := range reply {
+				if _, err := srv.Write([]byte{reply[i]}); err != nil {
+					return
+				}
+			}
+		}
+		line, err := readAuthLine(r)
+		if err != nil {
+			return
+		}
+		lines <- string(line)
+	}()
+
+	if err := conn.authenticate(new(AuthDbusCookieSha1)); err == nil {
+		t.Fatal("expected DBUS_COOKIE_SHA1 to be rejected")
+	}
+	if err := conn.authenticate(new(AuthExternal)); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for line := range lines {
+		got = append(got, line)
+	}
+	if len(got) != 3 || got[2] != "BEGIN" {
+		t.Errorf("server received %q, want AUTH, AUTH and BEGIN", got)
+	}
+}
+
+func TestAuthenticateUnixFd(t *testing.T) {
+	for _, reply := range []string{"AGREE_UNIX_FD", "ERROR not supported"} {
+		cli, srv := newSocketPair(t)
+		conn := &Connection{conn: cli}
+		conn.init()
+
+		lines := make(chan string, 3)
+		go func() {
+			defer close(lines)
+			r := bufio.NewReader(srv)
+			for _, answer := range []string{"OK 0123456789abcdef\r\n", reply + "\r\n", ""} {
+				line, err := readAuthLine(r)
+				if err != nil {
+					return
+				}
+				lines <- string(line)
+				srv.Write([]byte(answer))
+			}
+		}()
+
+		if err := conn.authenticate(new(AuthExternal)); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for line := range lines {
+			got = append(got, line)
+		}
+		if len(got) != 3 || got[1] != "NEGOTIATE_UNIX_FD" || got[2] != "BEGIN" {
+			t.Errorf("server received %q, want AUTH, NEGOTIATE_UNIX_FD and BEGIN", got)
+		}
+		if agreed := conn.fds != nil; agreed != (reply == "AGREE_UNIX_FD") {
+			t.Errorf("server replied %s: file descriptor passing is %v", reply, agreed)
+		}
+		cli.Close()
+		srv.Close()
+	}
+}
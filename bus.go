@@ -0,0 +1,222 @@
+package dbus
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Wrappers for the methods of the org.freedesktop.DBus interface.
+
+// callProxy calls a method of the message bus interface.
+func (p *Connection) callProxy(name string, args ...interface{}) ([]interface{}, error) {
+	method, err := p.proxy.Method(name)
+	if err != nil {
+		return nil, err
+	}
+	return p.Call(method, args...)
+}
+
+// ListQueuedOwners returns the unique names of the connections
+// queued for ownership of a bus name.
+func (p *Connection) ListQueuedOwners(name string) ([]string, error) {
+	out, err := p.callProxy("ListQueuedOwners", name)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) != 1 {
+		return nil, errors.New("unexpected reply to ListQueuedOwners")
+	}
+	return AsStrings(out[0])
+}
+
+// ListNames returns the names currently owned on the bus,
+// including unique connection names.
+func (p *Connection) ListNames() ([]string, error) {
+	out, err := p.callProxy("ListNames")
+	if err != nil {
+		return nil, err
+	}
+	if len(out) != 1 {
+		return nil, errors.New("unexpected reply to ListNames")
+	}
+	return AsStrings(out[0])
+}
+
+// ListActivatableNames returns the names of the services
+// the bus can start on demand.
+func (p *Connection) ListActivatableNames() ([]string, error) {
+	out, err := p.callProxy("ListActivatableNames")
+	if err != nil {
+		return nil, err
+	}
+	if len(out) != 1 {
+		return nil, errors.New("unexpected reply to ListActivatableNames")
+	}
+	return AsStrings(out[0])
+}
+
+// FindServices returns the sorted names, owned or activatable,
+// starting with prefix, such as "org.freedesktop.".
+func (p *Connection) FindServices(prefix string) ([]string, error) {
+	owned, err := p.ListNames()
+	if err != nil {
+		return nil, err
+	}
+	activatable, err := p.ListActivatableNames()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range append(owned, activatable...) {
+		if strings.HasPrefix(name, prefix) && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ReloadConfig asks the message bus to reload its configuration.
+func (p *Connection) ReloadConfig() error {
+	_, err := p.callProxy("ReloadConfig")
+	return err
+}
+
+// A MatchHandler is a match rule and the function handling the
+// signals it matches, as passed to Handle.
+type MatchHandler struct {
+	Rule    *MatchRule
+	Handler func(*Message)
+}
+
+// AddMatches registers several signal handlers, like Handle. The
+// AddMatch calls are sent in a single write instead of waiting for each
+// reply in turn, which speeds up applications subscribing to many
+// signals. If one of them fails, none of the handlers is registered.
+func (p *Connection) AddMatches(handlers ...MatchHandler) error {
+	method, err := p.proxy.Method("AddMatch")
+	if err != nil {
+		return err
+	}
+	batch := p.Pipeline()
+	hs := make([]*signalHandler, len(handlers))
+	for i, h := range handlers {
+		hs[i] = &signalHandler{*h.Rule, h.Handler}
+		batch.Add(method, h.Rule.String())
+	}
+	p.signalLock.Lock()
+	p.signalMatchRules = append(p.signalMatchRules, hs...)
+	p.signalLock.Unlock()
+	if _, err = batch.Do(); err != nil {
+		p.removeHandlers(hs...)
+		return err
+	}
+	return nil
+}
+
+const errNameUnknownInterface = "org.freedesktop.DBus.Error.UnknownInterface"
+
+type errNoBusStats struct{ E *DBusError }
+
+func (e errNoBusStats) Error() string {
+	return fmt.Sprintf("bus does not provide statistics: %s", e.E)
+}
+
+// GetBusStats returns the statistics of the message bus, as given by
+// org.freedesktop.DBus.Debug.Stats.GetStats. Only buses built with
+// debugging support provide them.
+func (p *Connection) GetBusStats() (map[string]interface{}, error) {
+	iface, err := p.proxy.obj.Interface("org.freedesktop.DBus.Debug.Stats")
+	if err != nil {
+		return nil, err
+	}
+	method, err := iface.Method("GetStats")
+	if err != nil {
+		return nil, err
+	}
+	out, err := p.Call(method)
+	if e, ok := err.(*DBusError); ok && (e.Name == errNameUnknownMethod || e.Name == errNameUnknownInterface) {
+		return nil, errNoBusStats{e}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(out) != 1 {
+		return nil, errors.New("unexpected reply to GetStats")
+	}
+	entries, ok := out[0].([]interface{})
+	if !ok {
+		return nil, errors.New("unexpected reply to GetStats")
+	}
+	stats := make(map[string]interface{}, len(entries))
+	for _, entry := range entries {
+		kv, ok := entry.([]interface{})
+		if !ok || len(kv) != 2 {
+			return nil, errors.New("unexpected reply to GetStats")
+		}
+		key, ok := kv[0].(string)
+		if !ok {
+			return nil, errors.New("unexpected reply to GetStats")
+		}
+		stats[key] = kv[1]
+	}
+	return stats, nil
+}
+
+// BecomeMonitor turns the connection into a monitor receiving a copy
+// of the bus traffic matching rules (all the traffic if rules is empty).
+// Afterwards, every incoming message is passed to the function
+// registered with OnMessage, and the connection cannot be used to
+// make calls anymore.
+func (p *Connection) BecomeMonitor(rules []string, flags uint32) error {
+	iface, err := p.proxy.obj.Interface("org.freedesktop.DBus.Monitoring")
+	if err != nil {
+		return err
+	}
+	method, err := iface.Method("BecomeMonitor")
+	if err != nil {
+		return err
+	}
+	if rules == nil {
+		rules = []string{}
+	}
+	args := make([]interface{}, len(rules))
+	for i, rule := range rules {
+		args[i] = rule
+	}
+	// The bus forwards the monitored traffic as soon as it replies,
+	// possibly before the reply is received.
+	p.monitorLock.Lock()
+	p.monitoring = true
+	p.monitorLock.Unlock()
+	if _, err = p.Call(method, args, flags); err != nil {
+		p.monitorLock.Lock()
+		p.monitoring = false
+		p.monitorLock.Unlock()
+		return err
+	}
+	return nil
+}
+
+// OnMessage registers the function receiving the messages
+// of a monitor connection (see BecomeMonitor).
+func (p *Connection) OnMessage(proc func(*Message)) {
+	p.monitorLock.Lock()
+	p.onMessage = proc
+	p.monitorLock.Unlock()
+}
+
+// monitor returns the function receiving all messages
+// if the connection is a monitor.
+func (p *Connection) monitor() func(*Message) {
+	p.monitorLock.Lock()
+	defer p.monitorLock.Unlock()
+	if !p.monitoring || p.onMessage == nil {
+		return nil
+	}
+	return p.onMessage
+}
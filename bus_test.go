@@ -0,0 +1,269 @@
+package dbus
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestListQueuedOwners(t *testing.T) {
+	var name interface{}
+	conn := newTestConnection(func(msg *Message) *Message {
+		if msg.Member != "ListQueuedOwners" {
+			return newTestReply(msg, "")
+		}
+		name = msg.Params[0]
+		return newTestReply(msg, "as", []interface{}{":1.1", ":1.42"})
+	})
+	owners, err := conn.ListQueuedOwners("org.example.Service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "org.example.Service" {
+		t.Errorf("got name %v, want org.example.Service", name)
+	}
+	if !reflect.DeepEqual(owners, []string{":1.1", ":1.42"}) {
+		t.Errorf("got %q", owners)
+	}
+}
+
+func TestFindServices(t *testing.T) {
+	conn := newTestConnection(func(msg *Message) *Message {
+		switch msg.Member {
+		case "ListNames":
+			return newTestReply(msg, "as", []interface{}{
+				"org.freedesktop.DBus", ":1.3", "org.freedesktop.systemd1", "org.example.Service"})
+		case "ListActivatableNames":
+			return newTestReply(msg, "as", []interface{}{
+				"org.freedesktop.DBus", "org.freedesktop.hostname1", "com.example.Other"})
+		}
+		return newTestReply(msg, "")
+	})
+	names, err := conn.FindServices("org.freedesktop.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"org.freedesktop.DBus", "org.freedesktop.hostname1", "org.freedesktop.systemd1"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got %q, want %q", names, want)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	var call *Message
+	conn := newTestConnection(func(msg *Message) *Message {
+		if msg.Member == "ReloadConfig" {
+			call = msg
+		}
+		return newTestReply(msg, "")
+	})
+	if err := conn.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if call == nil {
+		t.Fatal("ReloadConfig was not called")
+	}
+	if call.Type != TypeMethodCall || call.Path != "/org/freedesktop/DBus" ||
+		call.Iface != "org.freedesktop.DBus" || call.Dest != "org.freedesktop.DBus" {
+		t.Errorf("bad method call header: %+v", call)
+	}
+	if call.Sig != "" || call.bodyLength != 0 {
+		t.Errorf("got signature %q and body length %d, want empty body", call.Sig, call.bodyLength)
+	}
+}
+
+func TestReloadConfigDenied(t *testing.T) {
+	conn := newTestConnection(func(msg *Message) *Message {
+		return newTestError(msg, "org.freedesktop.DBus.Error.AccessDenied", "not allowed")
+	})
+	err := conn.ReloadConfig()
+	e, ok := err.(*DBusError)
+	if !ok {
+		t.Fatalf("got %v, want a *DBusError", err)
+	}
+	if e.Name != "org.freedesktop.DBus.Error.AccessDenied" || e.Message != "not allowed" {
+		t.Errorf("got %+v", e)
+	}
+}
+
+func TestAddMatches(t *testing.T) {
+	var added []string
+	removed := make(chan string, 3)
+	conn, bus := newTestBus(func(msg *Message) *Message {
+		switch msg.Member {
+		case "AddMatch":
+			added = append(added, msg.Params[0].(string))
+		case "RemoveMatch":
+			removed <- msg.Params[0].(string)
+			return nil
+		}
+		return newTestReply(msg, "")
+	})
+	received := make(chan *Message, 1)
+	handlers := []MatchHandler{
+		{&MatchRule{Type: TypeSignal, Interface: "org.example.A"}, func(*Message) {}},
+		{&MatchRule{Type: TypeSignal, Interface: "org.example.B", Member: "Changed"}, func(msg *Message) { received <- msg }},
+		{&MatchRule{Type: TypeSignal, PathNamespace: "/org/example/C"}, func(*Message) {}},
+	}
+	if err := conn.AddMatches(handlers...); err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != len(handlers) {
+		t.Fatalf("got %d AddMatch calls, want %d", len(added), len(handlers))
+	}
+	for i, h := range handlers {
+		if added[i] != h.Rule.String() {
+			t.Errorf("got AddMatch(%q), want %q", added[i], h.Rule.String())
+		}
+	}
+
+	sendTestMessage(t, bus, newTestSignal("org.example.B", "Changed", ""))
+	select {
+	case msg := <-received:
+		if msg.Iface != "org.example.B" {
+			t.Errorf("handler received %s.%s", msg.Iface, msg.Member)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for signal")
+	}
+
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range handlers {
+		select {
+		case s := <-removed:
+			if s != h.Rule.String() {
+				t.Errorf("got RemoveMatch(%q), want %q", s, h.Rule.String())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no RemoveMatch call for %q", h.Rule.String())
+		}
+	}
+}
+
+func TestGetBusStats(t *testing.T) {
+	conn := newTestConnection(func(msg *Message) *Message {
+		if msg.Iface != "org.freedesktop.DBus.Debug.Stats" || msg.Member != "GetStats" {
+			return newTestReply(msg, "")
+		}
+		return newTestReply(msg, "a{sv}", []interface{}{
+			[]interface{}{"Serial", uint32(12)},
+			[]interface{}{"ActiveConnections", uint32(3)},
+		})
+	})
+	stats, err := conn.GetBusStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"Serial": uint32(12), "ActiveConnections": uint32(3)}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("got %#v, want %#v", stats, want)
+	}
+}
+
+func TestGetBusStatsBadReply(t *testing.T) {
+	conn := newTestConnection(func(msg *Message) *Message {
+		return newTestReply(msg, "u", uint32(12))
+	})
+	if _, err := conn.GetBusStats(); err == nil {
+		t.Error("expected an error for a reply of type u")
+	}
+}
+
+func TestGetBusStatsUnavailable(t *testing.T) {
+	conn := newTestConnection(func(msg *Message) *Message {
+		return newTestError(msg, errNameUnknownInterface, "no such interface")
+	})
+	_, err := conn.GetBusStats()
+	e, ok := err.(errNoBusStats)
+	if !ok {
+		t.Fatalf("got %v, want errNoBusStats", err)
+	}
+	if e.E.Name != errNameUnknownInterface {
+		t.Errorf("got error name %q", e.E.Name)
+	}
+}
+
+func TestBecomeMonitor(t *testing.T) {
+	var call *Message
+	conn, bus := newTestBus(func(msg *Message) *Message {
+		if msg.Member == "BecomeMonitor" {
+			call = msg
+		}
+		return newTestReply(msg, "")
+	})
+	messages := make(chan *Message, 1)
+	conn.OnMessage(func(msg *Message) { messages <- msg })
+	rules := []string{"type='signal'", "type='method_call',interface='org.example'"}
+	if err := conn.BecomeMonitor(rules, 0); err != nil {
+		t.Fatal(err)
+	}
+	if call == nil {
+		t.Fatal("BecomeMonitor was not called")
+	}
+	if call.Iface != "org.freedesktop.DBus.Monitoring" || call.Path != "/org/freedesktop/DBus" ||
+		call.Dest != "org.freedesktop.DBus" || call.Sig != "asu" {
+		t.Errorf("bad BecomeMonitor header: %+v", call)
+	}
+	want := []interface{}{[]interface{}{rules[0], rules[1]}, uint32(0)}
+	if !reflect.DeepEqual(call.Params, want) {
+		t.Errorf("got arguments %#v, want %#v", call.Params, want)
+	}
+
+	// Method calls are now passed to OnMessage.
+	observed := NewMessage()
+	observed.Type = TypeMethodCall
+	observed.Path = "/org/example"
+	observed.Iface = "org.example"
+	observed.Member = "Frobate"
+	sendTestMessage(t, bus, observed)
+	select {
+	case msg := <-messages:
+		if msg.Member != "Frobate" || msg.Type != TypeMethodCall {
+			t.Errorf("got %s %s", msg.Type, msg.Member)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("monitored message not received")
+	}
+}
+
+func TestBecomeMonitorEarlyTraffic(t *testing.T) {
+	var conn *Connection
+	var bus net.Conn
+	conn, bus = newTestBus(func(msg *Message) *Message {
+		if msg.Member == "BecomeMonitor" {
+			// Monitored traffic arrives before the reply.
+			b, _ := NewCall("org.example", "/org/example", "org.example", "Frobate")._Marshal()
+			bus.Write(b)
+		}
+		return newTestReply(msg, "")
+	})
+	messages := make(chan *Message, 1)
+	conn.OnMessage(func(msg *Message) { messages <- msg })
+	if err := conn.BecomeMonitor(nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-messages:
+		if msg.Member != "Frobate" {
+			t.Errorf("got %s %s", msg.Type, msg.Member)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("monitored message not received")
+	}
+}
+
+func TestBecomeMonitorError(t *testing.T) {
+	conn := newTestConnection(func(msg *Message) *Message {
+		return newTestError(msg, "org.freedesktop.DBus.Error.AccessDenied", "not allowed")
+	})
+	conn.OnMessage(func(*Message) {})
+	if err := conn.BecomeMonitor(nil, 0); err == nil {
+		t.Fatal("expected an error")
+	}
+	if conn.monitor() != nil {
+		t.Error("connection still monitoring after BecomeMonitor failed")
+	}
+}
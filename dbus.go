@@ -2,6 +2,7 @@ package dbus
 
 import (
 	"bufio"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -9,8 +10,11 @@ import (
 	"log"
 	"net"
 	"os"
-	"strings"
+	"reflect"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 func init() {
@@ -102,6 +106,17 @@ const dbusXMLIntro = `
       <arg type="s"/>
     </signal>
   </interface>
+  <interface name="org.freedesktop.DBus.Monitoring">
+    <method name="BecomeMonitor">
+      <arg direction="in" type="as"/>
+      <arg direction="in" type="u"/>
+    </method>
+  </interface>
+  <interface name="org.freedesktop.DBus.Debug.Stats">
+    <method name="GetStats">
+      <arg direction="out" type="a{sv}"/>
+    </method>
+  </interface>
 </node>`
 
 type signalHandler struct {
@@ -112,18 +127,68 @@ type signalHandler struct {
 type Connection struct {
 	addressMap       map[string]string
 	uniqName         string
-	signalMatchRules []signalHandler
+	signalMatchRules []*signalHandler
 	conn             net.Conn
 	proxy            *Interface
+	// received signals, waiting for delivery to the handlers.
+	signalLock  sync.Mutex
+	signalQueue chan *Message
+	// closed once no more signals are delivered.
+	signalsDone chan struct{}
 	// reply channels.
-	replyChans map[uint32]chan<- []byte
-	replyLock  sync.Mutex
+	replyChans  map[uint32]chan<- *Message
+	replyLock   sync.Mutex
+	replyBuffer int
+	// file descriptors received on unix sockets.
+	fds *fdReader
+	// whether to activate unknown services and retry calls.
+	autoStart bool
+	// receives the error which stopped the dispatch loop.
+	errChan chan error
+	// 1 while the dispatch loop is running.
+	alive int32
+	// errors decoding subscribed signals.
+	decodeErrs chan error
+	// buffering of the channels returned by SubscribeTyped,
+	// protected by signalLock.
+	signalBuffer int
+	signalPolicy SignalPolicy
+	// duration allowed for authentication.
+	authTimeout time.Duration
+	// duration calls wait for their reply, if positive.
+	callTimeout time.Duration
+	authReader  *bufio.Reader
+	// whether unknown header fields are rejected.
+	strictHeaders bool
+	// whether decoded strings are validated.
+	strictStrings bool
+	// whether received messages keep their raw bytes.
+	keepRaw bool
+	// functions run on outgoing messages, in order.
+	middlewareLock sync.Mutex
+	middlewares    []func(*Message) error
+	// whether emitted signals carry the unique name as sender.
+	senderField bool
+	// monitor mode: all messages are passed to onMessage.
+	monitorLock sync.Mutex
+	monitoring  bool
+	onMessage   func(*Message)
+	// methods served by the connection, by path and interface.
+	exportLock sync.Mutex
+	exported   map[string]map[string]map[string]ExportedMethod
+	// one token per incoming method call being handled.
+	callSlots chan struct{}
 }
 
 type Object struct {
 	dest  string
 	path  string
 	intro Introspect
+	conn  *Connection // the connection the object was obtained from.
+	err   error       // the introspection error, if intro is nil.
+	// duration calls wait for their reply, overriding
+	// the connection default if positive.
+	timeout time.Duration
 }
 
 type Interface struct {
@@ -160,7 +225,40 @@ func (iface *Interface) Signal(name string) (*Signal, error) {
 	return &Signal{iface, signal}, nil
 }
 
+// MatchRule returns a rule matching emissions of the signal
+// by its object, suitable for Connection.Handle.
+func (p *Signal) MatchRule() *MatchRule {
+	return &MatchRule{
+		Type:      TypeSignal,
+		Interface: p.iface.name,
+		Member:    p.data.GetName(),
+		Path:      p.iface.obj.path,
+	}
+}
+
+// SystemBusPath is the path of the system bus socket, used when
+// DBUS_SYSTEM_BUS_ADDRESS is unset. If it does not exist, the
+// /run/dbus/system_bus_socket used by some distributions is tried.
+var SystemBusPath = "/var/run/dbus/system_bus_socket"
+
+var runSystemBusPath = "/run/dbus/system_bus_socket"
+
+func systemBusPath() string {
+	if _, err := os.Stat(SystemBusPath); err != nil {
+		if _, err := os.Stat(runSystemBusPath); err == nil {
+			return runSystemBusPath
+		}
+	}
+	return SystemBusPath
+}
+
 func Connect(busType StandardBus) (*Connection, error) {
+	return ConnectContext(context.Background(), busType)
+}
+
+// ConnectContext is like Connect but aborts connecting to the
+// bus when ctx is done.
+func ConnectContext(ctx context.Context, busType StandardBus) (*Connection, error) {
 	var address string
 
 	switch busType {
@@ -169,7 +267,7 @@ func Connect(busType StandardBus) (*Connection, error) {
 
 	case SystemBus:
 		if address = os.Getenv("DBUS_SYSTEM_BUS_ADDRESS"); len(address) == 0 {
-			address = "unix:path=/var/run/dbus/system_bus_socket"
+			address = "unix:path=" + systemBusPath()
 		}
 
 	default:
@@ -179,14 +277,13 @@ func Connect(busType StandardBus) (*Connection, error) {
 	if len(address) == 0 {
 		return nil, errors.New("Unknown bus address")
 	}
-	transport := address[:strings.Index(address, ":")]
+	transport, params, err := ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
 
 	bus := new(Connection)
-	bus.addressMap = make(map[string]string)
-	for _, pair := range strings.Split(address[len(transport)+1:], ",") {
-		pair := strings.Split(pair, "=")
-		bus.addressMap[pair[0]] = pair[1]
-	}
+	bus.addressMap = params
 
 	var ok bool
 	if address, ok = bus.addressMap["path"]; ok {
@@ -196,34 +293,296 @@ func Connect(busType StandardBus) (*Connection, error) {
 		return nil, errors.New("Unknown address key")
 	}
 
-	var err error
-	if bus.conn, err = net.Dial(transport, address); err != nil {
+	var dialer net.Dialer
+	if bus.conn, err = dialer.DialContext(ctx, transport, address); err != nil {
 		return nil, err
 	}
 
 	if _, err = bus.conn.Write([]byte{0}); err != nil {
+		bus.conn.Close()
 		return nil, err
 	}
 
-	bus.replyChans = make(map[uint32]chan<- []byte)
-	bus.signalMatchRules = make([]signalHandler, 0)
-	bus.proxy = bus._GetProxy()
+	bus.init()
 	return bus, nil
 }
 
+// ConnectFromFD returns a connection over the already connected
+// socket fd, for example one passed by socket activation (see
+// ListenFDs). The connection takes ownership of fd. As with Connect,
+// Authenticate must be called before using it.
+func ConnectFromFD(fd int) (*Connection, error) {
+	f := os.NewFile(uintptr(fd), "dbus")
+	conn, err := net.FileConn(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write([]byte{0}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	bus := &Connection{conn: conn}
+	bus.init()
+	return bus, nil
+}
+
+// listenFDsStart is the first file descriptor passed by
+// socket activation.
+const listenFDsStart = 3
+
+// ListenFDs returns the file descriptors passed to the process by
+// socket activation, following the systemd LISTEN_FDS convention.
+// It returns none if the variables are unset or meant for another
+// process.
+func ListenFDs() ([]int, error) {
+	pid := os.Getenv("LISTEN_PID")
+	if pid == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	fds := make([]int, n)
+	for i := range fds {
+		fds[i] = listenFDsStart + i
+	}
+	return fds, nil
+}
+
+// init prepares the dispatch state of a connection whose
+// underlying transport is already established.
+func (p *Connection) init() {
+	p.replyChans = make(map[uint32]chan<- *Message)
+	p.errChan = make(chan error, 1)
+	p.decodeErrs = make(chan error, 16)
+	p.signalBuffer = 16
+	p.authTimeout = DefaultAuthTimeout
+	if conn, ok := p.conn.(*net.UnixConn); ok {
+		p.fds = &fdReader{conn: conn}
+	}
+	p.signalMatchRules = make([]*signalHandler, 0)
+	p.signalQueue = make(chan *Message, signalQueueSize)
+	p.signalsDone = make(chan struct{})
+	p.replyBuffer = 1
+	p.callSlots = make(chan struct{}, maxCallHandlers)
+	p.exported = make(map[string]map[string]map[string]ExportedMethod)
+	p.proxy = p._GetProxy()
+}
+
 func (p *Connection) Authenticate() error {
+	return p.AuthenticateContext(context.Background())
+}
+
+// AuthenticateContext is like Authenticate but aborts the
+// authentication handshake when ctx is done.
+func (p *Connection) AuthenticateContext(ctx context.Context) error {
+	if p.authTimeout > 0 {
+		p.conn.SetReadDeadline(time.Now().Add(p.authTimeout))
+	}
+	stop := p.watchContext(ctx)
 	err := p.authenticate(new(AuthDbusCookieSha1))
-	if err != nil {
+	if err != nil && ctx.Err() == nil && !isTimeout(err) {
 		err = p.authenticate(new(AuthExternal))
 	}
+	stop()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if isTimeout(err) {
+		return errAuthTimeout
+	}
 	if err != nil {
 		return err
 	}
-	go p.handleReplies()
+	p.conn.SetReadDeadline(time.Time{})
+	p.start()
 	p._SendHello()
 	return nil
 }
 
+// DefaultAuthTimeout is the default duration allowed for
+// the authentication handshake.
+const DefaultAuthTimeout = 30 * time.Second
+
+var errAuthTimeout = errors.New("authentication timed out")
+
+// SetAuthTimeout sets the duration allowed for the authentication
+// handshake, after which Authenticate fails. A zero duration means
+// no timeout.
+func (p *Connection) SetAuthTimeout(d time.Duration) {
+	p.authTimeout = d
+}
+
+func isTimeout(err error) bool {
+	e, ok := err.(net.Error)
+	return ok && e.Timeout()
+}
+
+// watchContext interrupts pending I/O on the connection when ctx
+// is done. The returned function stops watching ctx.
+func (p *Connection) watchContext(ctx context.Context) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			p.conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// start launches the dispatch loop.
+func (p *Connection) start() {
+	atomic.StoreInt32(&p.alive, 1)
+	go p.deliverSignals()
+	go p.run()
+}
+
+// run runs the dispatch loop and reports its terminal error.
+func (p *Connection) run() {
+	err := p.handleReplies()
+	close(p.signalQueue)
+	atomic.StoreInt32(&p.alive, 0)
+	p.errChan <- err
+	close(p.errChan)
+}
+
+// signalQueueSize is the number of received signals waiting for
+// delivery after which the connection stops reading messages.
+const signalQueueSize = 64
+
+// queueSignal queues a received signal for delivery. Signals are
+// delivered by their own goroutine, so that slow handlers do not
+// delay method replies, until signalQueueSize signals are pending:
+// queueSignal then blocks until the handlers catch up.
+func (p *Connection) queueSignal(msg *Message) {
+	p.signalQueue <- msg
+}
+
+// deliverSignals passes the queued signals, in order, to the
+// handlers of the match rules they match.
+func (p *Connection) deliverSignals() {
+	defer close(p.signalsDone)
+	for msg := range p.signalQueue {
+		p.signalLock.Lock()
+		handlers := p.signalMatchRules
+		p.signalLock.Unlock()
+		for _, handler := range handlers {
+			if handler.mr._Match(msg) {
+				handler.proc(msg)
+			}
+		}
+	}
+}
+
+// SetStrictHeaders controls whether received messages carrying header
+// fields unknown to the specification are dropped. By default these
+// fields are ignored. It must be called before Authenticate.
+func (p *Connection) SetStrictHeaders(strict bool) {
+	p.strictHeaders = strict
+}
+
+// SetStrictStrings controls whether strings received in message bodies
+// must be valid UTF-8, object paths and signatures being checked
+// against their own syntax. Messages with invalid strings then fail to
+// decode. By default strings are not validated.
+func (p *Connection) SetStrictStrings(strict bool) {
+	p.strictStrings = strict
+}
+
+// SetKeepRaw controls whether received messages keep the bytes they
+// were decoded from, returned by their Raw method, for example to
+// record a faithful capture of the traffic. By default they do not.
+// It must be called before Authenticate.
+func (p *Connection) SetKeepRaw(keep bool) {
+	p.keepRaw = keep
+}
+
+// Conn returns the underlying connection to the bus, for example to
+// tune socket options. Reading from it or writing to it bypasses the
+// library and corrupts the message stream.
+func (p *Connection) Conn() net.Conn {
+	return p.conn
+}
+
+// IsConnected reports whether the connection is usable: its
+// dispatch loop is running and it has not been closed.
+func (p *Connection) IsConnected() bool {
+	return atomic.LoadInt32(&p.alive) == 1
+}
+
+// Close closes the connection. The match rules registered with
+// Handle are removed from the bus beforehand, on a best-effort basis.
+func (p *Connection) Close() error {
+	if p.IsConnected() {
+		p.signalLock.Lock()
+		handlers := p.signalMatchRules
+		p.signalLock.Unlock()
+		for _, handler := range handlers {
+			p.removeMatch(&handler.mr)
+		}
+	}
+	atomic.StoreInt32(&p.alive, 0)
+	return p.conn.Close()
+}
+
+// removeMatch sends a RemoveMatch call without waiting for its reply.
+func (p *Connection) removeMatch(rule *MatchRule) error {
+	method, err := p.proxy.Method("RemoveMatch")
+	if err != nil {
+		return err
+	}
+	msg := newCall(method, []interface{}{rule.String()}, false)
+	msg.Flags |= FlagNoReplyExpected
+	rawmsg, err := p.marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = p.conn.Write(rawmsg)
+	return err
+}
+
+// Use installs a middleware, run on every message sent through the
+// connection before it is marshalled. Middlewares run in the order
+// they were installed. They may modify the message, or reject it by
+// returning an error, which the sending method then returns.
+func (p *Connection) Use(mw func(*Message) error) {
+	p.middlewareLock.Lock()
+	p.middlewares = append(p.middlewares, mw)
+	p.middlewareLock.Unlock()
+}
+
+// marshal runs the middlewares on msg and marshals it.
+func (p *Connection) marshal(msg *Message) ([]byte, error) {
+	p.middlewareLock.Lock()
+	mws := p.middlewares
+	p.middlewareLock.Unlock()
+	for _, mw := range mws {
+		if err := mw(msg); err != nil {
+			return nil, err
+		}
+	}
+	return msg._Marshal()
+}
+
+// Err returns a channel receiving the error which terminated
+// the connection, once its dispatch loop has stopped reading
+// messages. The channel is closed afterwards.
+func (p *Connection) Err() <-chan error {
+	return p.errChan
+}
+
 type errMalformedEndianness byte
 
 func (e errMalformedEndianness) Error() string {
@@ -236,36 +595,81 @@ func (e errIncompleteMessage) Error() string {
 	return fmt.Sprintf("incomplete message data: %s", e.E)
 }
 
+type errFieldsSize uint32
+
+func (e errFieldsSize) Error() string {
+	return fmt.Sprintf("header fields are %d bytes long, more than the maximum of %d", uint32(e), maxArrayLength)
+}
+
+type errMessageSize uint64
+
+func (e errMessageSize) Error() string {
+	return fmt.Sprintf("message is %d bytes long, more than the maximum of %d", uint64(e), maxMessageLength)
+}
+
 // handleReplies reads messages from the connection and dispatches
 // them to the client goroutines.
 func (p *Connection) handleReplies() error {
-	r := bufio.NewReader(p.conn)
+	var r *bufio.Reader
+	if p.fds != nil {
+		r = bufio.NewReader(p.fds)
+	} else {
+		r = bufio.NewReader(p.conn)
+	}
 	for {
 		// Get message.
-		msg, replyTo, err := popMessage(r)
+		raw, replyTo, err := popMessage(r)
 		if err != nil {
 			return err
 		}
+		msg, err := parseRawMessage(raw, p.strictHeaders)
+		if err != nil {
+			logPrint(err)
+			if p.fds != nil {
+				// Do not hand its file descriptors to the next message.
+				p.fds.discard(rawNumFds(raw))
+			}
+			continue
+		}
+		if p.fds != nil {
+			msg.Fds = p.fds.take(msg.numFds)
+			msg.fdFiles()
+		}
+		msg.strict = p.strictStrings
+		if p.keepRaw {
+			msg.frame = raw
+		}
+		if proc := p.monitor(); proc != nil {
+			// Replies to the connection itself, such as the reply
+			// to BecomeMonitor, are still dispatched.
+			if (msg.Type == TypeMethodReturn || msg.Type == TypeError) &&
+				replyTo != 0 && msg.Dest == p.uniqName && p.dispatch(replyTo, msg) == nil {
+				continue
+			}
+			if err := msg.parseParams(); err != nil {
+				logPrint(err)
+			}
+			proc(msg)
+			continue
+		}
 
-		switch MessageType(msg[msgOffsetType]) {
-		case TypeInvalid, TypeMethodCall:
+		switch msg.Type {
+		case TypeInvalid:
 			// unsupported.
+		case TypeMethodCall:
+			p.serveCall(msg)
 		case TypeMethodReturn, TypeError:
-			// Dispatch.
+			// Dispatch. Replies nobody waits for, such as late
+			// replies to calls which timed out, are dropped.
 			err = p.dispatch(replyTo, msg)
-			if err != nil {
-				log.Print(err)
+			if _, ok := err.(errUnknownSerial); !ok && err != nil {
+				logPrint(err)
 			}
 		case TypeSignal:
-			reply, err := unmarshal(msg)
-			if err != nil {
-				log.Print(err)
-			}
-			for _, handler := range p.signalMatchRules {
-				if handler.mr._Match(reply) {
-					handler.proc(reply)
-				}
+			if err := msg.parseParams(); err != nil {
+				logPrint(err)
 			}
+			p.queueSignal(msg)
 		}
 	}
 	panic("unreachable")
@@ -279,31 +683,27 @@ const (
 	msgOffsetFieldsSize = 12
 )
 
+// maxArrayLength is the maximum length of an array, such as the
+// header fields, allowed by the specification.
+const maxArrayLength = 1 << 26
+
+// maxMessageLength is the maximum length of a message allowed by
+// the specification.
+const maxMessageLength = 1 << 27
+
 func popMessage(r *bufio.Reader) (msg []byte, serial uint32, err error) {
 	// Read message header.
 	header, err := r.Peek(16)
 	if err != nil {
 		return
 	}
-	order := binary.ByteOrder(nil)
-	switch header[0] {
-	case 'l':
-		order = binary.LittleEndian
-	case 'B':
-		order = binary.BigEndian
-	default:
-		err = errMalformedEndianness(header[0])
+	layout, err := HeaderLayout(header)
+	if err != nil {
 		return
 	}
 
-	// Determine length
-	bodySize := order.Uint32(header[msgOffsetBodySize : msgOffsetBodySize+4])
-	serial = order.Uint32(header[msgOffsetSerial : msgOffsetSerial+4])
-	fldSize := order.Uint32(header[msgOffsetFieldsSize : msgOffsetFieldsSize+4])
-	fldSize = (fldSize + 7) &^ 7 // pad.
-
 	// Read entire message.
-	msg = make([]byte, 16+fldSize+bodySize)
+	msg = make([]byte, layout.BodyOffset+layout.BodyLength)
 	_, err = io.ReadFull(r, msg)
 	if err != nil {
 		err = errIncompleteMessage{err}
@@ -311,19 +711,90 @@ func popMessage(r *bufio.Reader) (msg []byte, serial uint32, err error) {
 	}
 
 	// Find reply serial.
-	decoder := &msgData{ByteOrder: order, Data: msg}
+	decoder := &msgData{ByteOrder: layout.ByteOrder, Data: msg}
 	_, flds, _ := decoder.scanHeader()
 	return msg, flds.ReplySerial, nil
 }
 
+// A HeaderInfo describes where the parts of a message lie in its
+// raw bytes.
+type HeaderInfo struct {
+	FieldsLength int // Length of the header fields array, from offset 16.
+	Padding      int // Padding between the header fields and the body.
+	BodyOffset   int
+	BodyLength   int
+	ByteOrder    binary.ByteOrder
+}
+
+// HeaderLayout computes the layout of the message starting at raw
+// from its fixed header, without decoding the header fields: only the
+// first 16 bytes of the message are needed.
+func HeaderLayout(raw []byte) (HeaderInfo, error) {
+	if len(raw) < 16 {
+		return HeaderInfo{}, errIncompleteMessage{io.ErrUnexpectedEOF}
+	}
+	var order binary.ByteOrder
+	switch raw[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return HeaderInfo{}, errMalformedEndianness(raw[0])
+	}
+	fldSize := order.Uint32(raw[msgOffsetFieldsSize : msgOffsetFieldsSize+4])
+	if fldSize > maxArrayLength {
+		return HeaderInfo{}, errFieldsSize(fldSize)
+	}
+	bodyOffset := (16 + fldSize + 7) &^ 7 // pad.
+	bodySize := order.Uint32(raw[msgOffsetBodySize : msgOffsetBodySize+4])
+	if size := uint64(bodyOffset) + uint64(bodySize); size > maxMessageLength {
+		return HeaderInfo{}, errMessageSize(size)
+	}
+	info := HeaderInfo{
+		FieldsLength: int(fldSize),
+		Padding:      int(bodyOffset - 16 - fldSize),
+		BodyOffset:   int(bodyOffset),
+		BodyLength:   int(bodySize),
+		ByteOrder:    order,
+	}
+	return info, nil
+}
+
+// A DBusError is an error reply received from a peer.
+type DBusError struct {
+	Name    string
+	Message string
+	// Args holds all the arguments of the error reply,
+	// the first of which is usually Message.
+	Args []interface{}
+}
+
+func (e *DBusError) Error() string {
+	if e.Message == "" {
+		return e.Name
+	}
+	return e.Name + ": " + e.Message
+}
+
+// newDBusError builds the error represented by an error reply.
+func newDBusError(reply *Message) *DBusError {
+	e := &DBusError{Name: reply.ErrorName}
+	if reply.parseParams() == nil && len(reply.Params) > 0 {
+		e.Message, _ = reply.Params[0].(string)
+		e.Args = reply.Params
+	}
+	return e
+}
+
 type errUnknownSerial uint32
 
 func (e errUnknownSerial) Error() string {
 	return fmt.Sprintf("message for unknown serial number %d", uint32(e))
 }
 
-// dispatch sends a raw message to the appropriate goroutine.
-func (p *Connection) dispatch(serial uint32, rawmsg []byte) error {
+// dispatch sends a message to the appropriate goroutine.
+func (p *Connection) dispatch(serial uint32, msg *Message) error {
 	if serial == 0 {
 		return nil
 	}
@@ -334,43 +805,151 @@ func (p *Connection) dispatch(serial uint32, rawmsg []byte) error {
 	if ch == nil {
 		return errUnknownSerial(serial)
 	}
-	ch <- rawmsg
+	ch <- msg
 	return nil
 }
 
-// sendSync sends a message and synchronously waits fro the reply.
-func (p *Connection) sendSync(msg *Message) (*Message, error) {
-	rawmsg, err := msg._Marshal()
+// expectReply registers a channel receiving the reply to the
+// message with the given serial, with the given buffer size.
+func (p *Connection) expectReply(serial uint32, buffer int) <-chan *Message {
+	replyChan := make(chan *Message, buffer)
+	p.replyLock.Lock()
+	p.replyChans[serial] = replyChan
+	p.replyLock.Unlock()
+	return replyChan
+}
+
+// forgetReply stops expecting the reply to the message with the
+// given serial.
+func (p *Connection) forgetReply(serial uint32) {
+	p.replyLock.Lock()
+	delete(p.replyChans, serial)
+	p.replyLock.Unlock()
+}
+
+// waitReply waits for the reply to the message with the given serial
+// on replyChan, at most timeout if it is positive.
+func (p *Connection) waitReply(serial uint32, replyChan <-chan *Message, timeout time.Duration) (*Message, error) {
+	if timeout <= 0 {
+		return <-replyChan, nil
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case reply := <-replyChan:
+		return reply, nil
+	case <-timer.C:
+		p.replyLock.Lock()
+		_, pending := p.replyChans[serial]
+		delete(p.replyChans, serial)
+		p.replyLock.Unlock()
+		if !pending {
+			// The reply is being dispatched.
+			return <-replyChan, nil
+		}
+		return nil, errCallTimeout
+	}
+}
+
+// SetReplyBuffer sets the buffer size of the channel receiving the
+// reply to each method call, 1 by default. With 0, the dispatch loop
+// waits for each caller to take its reply before reading further
+// messages, so that replies are not read ahead of slow callers.
+// Replies to the calls of a Pipeline are always buffered.
+func (p *Connection) SetReplyBuffer(n int) {
+	if n < 0 {
+		n = 0
+	}
+	p.replyLock.Lock()
+	p.replyBuffer = n
+	p.replyLock.Unlock()
+}
+
+var errCallTimeout = errors.New("timed out waiting for reply")
+
+// SetCallTimeout sets the duration method calls wait for their reply,
+// after which they fail. A zero duration, the default, means no
+// timeout. Objects can override it with Object.SetTimeout.
+func (p *Connection) SetCallTimeout(d time.Duration) {
+	p.callTimeout = d
+}
+
+// SetTimeout sets the duration calls to the object wait for their
+// reply, overriding the default of the connection. A zero duration
+// restores the connection default.
+func (obj *Object) SetTimeout(d time.Duration) {
+	obj.timeout = d
+}
+
+// timeout returns the duration calls to obj wait for their reply.
+func (p *Connection) timeout(obj *Object) time.Duration {
+	if obj != nil && obj.timeout > 0 {
+		return obj.timeout
+	}
+	return p.callTimeout
+}
+
+// sendSync sends a message and synchronously waits fro the reply,
+// at most timeout if it is positive.
+func (p *Connection) sendSync(msg *Message, timeout time.Duration) (*Message, error) {
+	rawmsg, err := p.marshal(msg)
 	if err != nil {
 		return nil, err
 	}
 
 	// Prepare response channel.
-	seri := msg.serial
-	replyChan := make(chan []byte, 1)
 	p.replyLock.Lock()
-	p.replyChans[seri] = replyChan
+	buffer := p.replyBuffer
 	p.replyLock.Unlock()
+	replyChan := p.expectReply(msg.serial, buffer)
 	_, err = p.conn.Write(rawmsg)
 	if err != nil {
+		p.forgetReply(msg.serial)
 		// kill connection.
 		p.conn.Close()
 		return nil, err
 	}
 
 	// Receive reply.
-	rawreply := <-replyChan
-	return newRawMessage(rawreply)
+	return p.waitReply(msg.serial, replyChan, timeout)
 }
 
 func (p *Connection) _SendHello() error {
-	if method, err := p.proxy.Method("Hello"); err == nil {
-		p.Call(method)
+	method, err := p.proxy.Method("Hello")
+	if err != nil {
+		return err
+	}
+	out, err := p.Call(method)
+	if err != nil {
+		return err
+	}
+	if len(out) == 1 {
+		p.uniqName, _ = out[0].(string)
 	}
 	return nil
 }
 
-func (p *Connection) _GetIntrospect(dest string, path string) Introspect {
+// UniqueName returns the unique name assigned to the connection by
+// the bus, such as ":1.42", or "" before authentication.
+func (p *Connection) UniqueName() string {
+	return p.uniqName
+}
+
+// SetSignalSender controls whether emitted signals carry the unique
+// name of the connection in their sender header field. The bus fills
+// that field anyway, but peer-to-peer connections need it set.
+func (p *Connection) SetSignalSender(set bool) {
+	p.senderField = set
+}
+
+// setSender fills the sender of msg, a signal, if enabled.
+func (p *Connection) setSender(msg *Message) {
+	if p.senderField {
+		msg.Sender = p.uniqName
+	}
+}
+
+func (p *Connection) _GetIntrospect(dest string, path string) (Introspect, error) {
 	msg := NewMessage()
 	msg.Type = TypeMethodCall
 	msg.Path = path
@@ -378,25 +957,48 @@ func (p *Connection) _GetIntrospect(dest string, path string) Introspect {
 	msg.Iface = "org.freedesktop.DBus.Introspectable"
 	msg.Member = "Introspect"
 
-	reply, err := p.sendSync(msg)
-	var introxml string
-	err = reply.Unmarshal(&introxml)
+	reply, err := p.callMessage(msg, p.callTimeout)
 	if err != nil {
-		// TODO: handle error.
-		return nil
+		return nil, errNotIntrospectable{err}
+	}
+	var introxml string
+	if err = reply.Unmarshal(&introxml); err != nil {
+		return nil, errNotIntrospectable{err}
 	}
-
 	intro, err := NewIntrospect(introxml)
 	if err != nil {
-		intro = nil
+		return nil, errNotIntrospectable{err}
 	}
-	return intro
+	return intro, nil
+}
+
+type errNotIntrospectable struct{ E error }
+
+func (e errNotIntrospectable) Error() string {
+	return fmt.Sprintf("object is not introspectable: %s", e.E)
+}
+
+var (
+	errNilObject       = errors.New("nil object")
+	errNoIntrospection = errors.New("object has no introspection data")
+)
+
+type errUnknownInterface string
+
+func (e errUnknownInterface) Error() string {
+	return fmt.Sprintf("object has no interface %q", string(e))
 }
 
 // Retrieve an interface by name.
-func (obj *Object) Interface(name string) *Interface {
-	if obj == nil || obj.intro == nil {
-		return nil
+func (obj *Object) Interface(name string) (*Interface, error) {
+	if obj == nil {
+		return nil, errNilObject
+	}
+	if obj.intro == nil {
+		if obj.err != nil {
+			return nil, obj.err
+		}
+		return nil, errNoIntrospection
 	}
 
 	iface := new(Interface)
@@ -405,18 +1007,97 @@ func (obj *Object) Interface(name string) *Interface {
 
 	data := obj.intro.GetInterfaceData(name)
 	if nil == data {
-		return nil
+		return nil, errUnknownInterface(name)
 	}
 
 	iface.intro = data
 
-	return iface
+	return iface, nil
+}
+
+// GetProperty returns the value of property name of interface iface,
+// using org.freedesktop.DBus.Properties.Get. The variant holding the
+// value is unwrapped: a string property is returned as a string, an
+// array of strings as a []string.
+func (obj *Object) GetProperty(iface, name string) (interface{}, error) {
+	if obj == nil {
+		return nil, errNilObject
+	}
+	if obj.conn == nil {
+		return nil, errNoConnection
+	}
+	msg := NewCall(obj.dest, obj.path, "org.freedesktop.DBus.Properties", "Get")
+	msg.Sig = "ss"
+	msg.Params = []interface{}{iface, name}
+	reply, err := obj.conn.callMessage(msg, obj.conn.timeout(obj))
+	if err != nil {
+		return nil, err
+	}
+	if reply.Sig != "v" {
+		return nil, fmt.Errorf("%s.%s: property reply has signature %q, expected \"v\"",
+			iface, name, reply.Sig)
+	}
+	body := &msgData{ByteOrder: reply.byteOrder, Data: reply.raw, Files: reply.fdFiles()}
+	return body.scanVariant()
+}
+
+// SetProperty sets property name of interface iface to value, using
+// org.freedesktop.DBus.Properties.Set. The value is sent as a variant
+// of the introspected property type, or of the type given by
+// SignatureOf if the property is unknown. A Variant value is sent
+// as is.
+func (obj *Object) SetProperty(iface, name string, value interface{}) error {
+	if obj == nil {
+		return errNilObject
+	}
+	if obj.conn == nil {
+		return errNoConnection
+	}
+	v, ok := value.(Variant)
+	if !ok {
+		v.Value = value
+		if prop := obj.propertyData(iface, name); prop != nil {
+			v.Sig = Signature(prop.GetType())
+			sig, _, err := parseOneSignature(prop.GetType())
+			if err == nil {
+				err = checkValue(sig, value)
+			}
+			if err != nil {
+				return fmt.Errorf("%s.%s: %s", iface, name, err)
+			}
+		} else {
+			sig, err := variantSignature(value)
+			if err != nil {
+				return fmt.Errorf("%s.%s: %s", iface, name, err)
+			}
+			v.Sig = sig
+		}
+	}
+	msg := NewCall(obj.dest, obj.path, "org.freedesktop.DBus.Properties", "Set")
+	msg.Sig = "ssv"
+	msg.Params = []interface{}{iface, name, v}
+	_, err := obj.conn.callMessage(msg, obj.conn.timeout(obj))
+	return err
+}
+
+// propertyData returns the introspection data of a property,
+// or nil if it is unknown.
+func (obj *Object) propertyData(iface, name string) PropertyData {
+	if obj.intro == nil {
+		return nil
+	}
+	data := obj.intro.GetInterfaceData(iface)
+	if data == nil {
+		return nil
+	}
+	return data.GetPropertyData(name)
 }
 
 func (p *Connection) _GetProxy() *Interface {
 	obj := new(Object)
 	obj.path = "/org/freedesktop/DBus"
 	obj.dest = "org.freedesktop.DBus"
+	obj.conn = p
 	obj.intro, _ = NewIntrospect(dbusXMLIntro)
 
 	iface := new(Interface)
@@ -427,7 +1108,8 @@ func (p *Connection) _GetProxy() *Interface {
 	return iface
 }
 
-func (p *Connection) call(method *Method, args []interface{}, reflect bool) (*Message, error) {
+// newCall builds the method call message for method.
+func newCall(method *Method, args []interface{}, reflect bool) *Message {
 	iface := method.iface
 	msg := NewMessage()
 	msg.Type = TypeMethodCall
@@ -439,7 +1121,57 @@ func (p *Connection) call(method *Method, args []interface{}, reflect bool) (*Me
 
 	msg.Params = args
 	msg.reflect = reflect
-	return p.sendSync(msg)
+	return msg
+}
+
+func (p *Connection) call(method *Method, args []interface{}, reflect bool) (*Message, error) {
+	return p.callMethod(method, newCall(method, args, reflect))
+}
+
+// callMethod sends msg, a call to method, and waits for its reply.
+func (p *Connection) callMethod(method *Method, msg *Message) (*Message, error) {
+	timeout := p.timeout(method.iface.obj)
+	reply, err := p.callMessage(msg, timeout)
+	if e, ok := err.(*DBusError); ok && e.Name == errNameServiceUnknown && p.autoStart && msg.Dest != "" &&
+		msg.Flags&FlagNoAutoStart == 0 {
+		// Activate the service and retry once.
+		if _, err = p.callProxy("StartServiceByName", msg.Dest, uint32(0)); err != nil {
+			return nil, err
+		}
+		msg.serial = generateSerial()
+		reply, err = p.callMessage(msg, timeout)
+	}
+	if err == nil {
+		// The reply is decoded according to its own signature,
+		// which may differ from the introspection data.
+		if outSig := method.data.GetOutSignature(); reply.Sig != outSig {
+			logPrintf("%s.%s: reply signature %q differs from introspected signature %q",
+				msg.Iface, msg.Member, reply.Sig, outSig)
+		}
+	}
+	return reply, err
+}
+
+// callMessage sends a method call and waits for its reply, at most
+// timeout if it is positive, turning error replies into a *DBusError.
+func (p *Connection) callMessage(msg *Message, timeout time.Duration) (*Message, error) {
+	reply, err := p.sendSync(msg, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Type == TypeError {
+		return reply, newDBusError(reply)
+	}
+	return reply, nil
+}
+
+const errNameServiceUnknown = "org.freedesktop.DBus.Error.ServiceUnknown"
+
+// SetAutoStart controls whether a call to a service that is not
+// running (the bus replies with a ServiceUnknown error) should activate
+// the service with StartServiceByName and be retried once.
+func (p *Connection) SetAutoStart(autoStart bool) {
+	p.autoStart = autoStart
 }
 
 // Call a method with the given arguments. Complex arguments
@@ -454,6 +1186,86 @@ func (p *Connection) Call(method *Method, args ...interface{}) ([]interface{}, e
 	return reply.Params, err
 }
 
+// CallWithReplySig is like Call but decodes the reply body according
+// to replySig, ignoring the signature declared in the reply header.
+// It is meant for peers whose replies carry a missing or wrong
+// signature.
+func (p *Connection) CallWithReplySig(method *Method, replySig string, args ...interface{}) ([]interface{}, error) {
+	reply, err := p.call(method, args, false)
+	if err != nil {
+		return nil, err
+	}
+	reply.Sig = replySig
+	err = reply.parseParams()
+	return reply.Params, err
+}
+
+// CallNoAutoStart is like Call but asks the bus not to activate the
+// destination service if it is not running: the call then fails with
+// a ServiceUnknown error.
+func (p *Connection) CallNoAutoStart(method *Method, args ...interface{}) ([]interface{}, error) {
+	msg := newCall(method, args, false)
+	msg.Flags |= FlagNoAutoStart
+	reply, err := p.callMethod(method, msg)
+	if err != nil {
+		return nil, err
+	}
+	err = reply.parseParams()
+	return reply.Params, err
+}
+
+// CallSig is like Call but marshals the arguments according to sig
+// instead of the introspected in signature. It is meant for services
+// whose introspection data is wrong.
+func (p *Connection) CallSig(method *Method, sig string, args ...interface{}) ([]interface{}, error) {
+	msg := newCall(method, args, false)
+	msg.Sig = sig
+	reply, err := p.callMethod(method, msg)
+	if err != nil {
+		return nil, err
+	}
+	err = reply.parseParams()
+	return reply.Params, err
+}
+
+// CallTyped calls method member of the interface, on the connection
+// the object was obtained from. The arguments are checked against the
+// introspected in signature before sending, and the reply must match
+// the out signature.
+func (iface *Interface) CallTyped(member string, args ...interface{}) ([]interface{}, error) {
+	method, err := iface.Method(member)
+	if err != nil {
+		return nil, err
+	}
+	if iface.obj.conn == nil {
+		return nil, errNoConnection
+	}
+	sigs, err := parseSignature(method.data.GetInSignature())
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != len(sigs) {
+		return nil, errArity{Sig: method.data.GetInSignature(), Values: len(args)}
+	}
+	for i, sig := range sigs {
+		if err = checkValue(sig, args[i]); err != nil {
+			return nil, fmt.Errorf("%s.%s: argument %d: %s", iface.name, member, i, err)
+		}
+	}
+	reply, err := iface.obj.conn.call(method, args, false)
+	if err != nil {
+		return nil, err
+	}
+	if outSig := method.data.GetOutSignature(); reply.Sig != outSig {
+		return nil, fmt.Errorf("%s.%s: reply has signature %q, expected %q",
+			iface.name, member, reply.Sig, outSig)
+	}
+	err = reply.parseParams()
+	return reply.Params, err
+}
+
+var errNoConnection = errors.New("object is not bound to a connection")
+
 // Invoke calls a method Call a method with the given arguments.
 // Complex arguments like structs and arrays are represented by Go structs
 // and slices. The out arguments can be fetched by calling
@@ -475,28 +1287,195 @@ func (p *Connection) Emit(signal *Signal, args ...interface{}) error {
 	msg.Member = signal.data.GetName()
 	msg.Sig = signal.data.GetSignature()
 	msg.Params = args[:]
+	p.setSender(msg)
 
-	buff, _ := msg._Marshal()
-	_, err := p.conn.Write(buff)
-
+	buff, err := p.marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = p.conn.Write(buff)
 	return err
 }
 
+// EmitSignalTo emits a signal delivered only to the connection named
+// dest, instead of being broadcast.
+func (p *Connection) EmitSignalTo(dest, path, iface, member, sig string, args ...interface{}) error {
+	msg := NewMessage()
+	msg.Type = TypeSignal
+	msg.Dest = dest
+	msg.Path = path
+	msg.Iface = iface
+	msg.Member = member
+	msg.Sig = sig
+	msg.Params = args
+	p.setSender(msg)
+	return p.send(msg)
+}
+
+// Flush ensures that all the messages previously sent or emitted
+// through the connection have been handed to the underlying transport.
+// Messages are currently written synchronously, so Flush has nothing to
+// drain, but callers batching emissions should still call it.
+func (p *Connection) Flush() error {
+	return nil
+}
+
 // Retrieve a specified object.
 func (p *Connection) Object(dest string, path string) *Object {
 
 	obj := new(Object)
 	obj.path = path
 	obj.dest = dest
-	obj.intro = p._GetIntrospect(dest, path)
+	obj.conn = p
+	obj.intro, obj.err = p._GetIntrospect(dest, path)
 
 	return obj
 }
 
+// ObjectErr is like Object but also returns the error which occurred
+// while introspecting the object, if any. The object is returned even
+// then, so that it can be used without introspection data.
+func (p *Connection) ObjectErr(dest string, path string) (*Object, error) {
+	obj := p.Object(dest, path)
+	return obj, obj.err
+}
+
 // Handle received signals.
 func (p *Connection) Handle(rule *MatchRule, handler func(*Message)) {
-	p.signalMatchRules = append(p.signalMatchRules, signalHandler{*rule, handler})
-	if method, err := p.proxy.Method("AddMatch"); err == nil {
-		p.Call(method, rule.String())
+	p.handle(rule, handler)
+}
+
+func (p *Connection) handle(rule *MatchRule, handler func(*Message)) error {
+	h := &signalHandler{*rule, handler}
+	p.signalLock.Lock()
+	p.signalMatchRules = append(p.signalMatchRules, h)
+	p.signalLock.Unlock()
+	_, err := p.callProxy("AddMatch", rule.String())
+	if err != nil {
+		p.removeHandlers(h)
+	}
+	return err
+}
+
+// removeHandlers unregisters signal handlers. The list of handlers
+// is copied, as deliverSignals may be iterating over it.
+func (p *Connection) removeHandlers(hs ...*signalHandler) {
+	p.signalLock.Lock()
+	defer p.signalLock.Unlock()
+	handlers := make([]*signalHandler, 0, len(p.signalMatchRules))
+	for _, h := range p.signalMatchRules {
+		removed := false
+		for _, r := range hs {
+			removed = removed || h == r
+		}
+		if !removed {
+			handlers = append(handlers, h)
+		}
+	}
+	p.signalMatchRules = handlers
+}
+
+// A SignalPolicy tells what happens to a signal delivered to a
+// subscriber whose channel is full.
+type SignalPolicy int
+
+const (
+	// SignalBlock waits for the subscriber to receive the signal.
+	// Until then no other signal is delivered, and once 64 more
+	// signals are pending the connection stops reading messages,
+	// method replies included. This is the default.
+	SignalBlock SignalPolicy = iota
+	// SignalDrop discards the signal.
+	SignalDrop
+	// SignalGrow queues the signal without bound.
+	SignalGrow
+)
+
+// SetSignalPolicy sets the buffer size of the channels returned by
+// SubscribeTyped, 16 by default, and the policy applied when they are
+// full. It applies to subsequent subscriptions. The buffering of
+// method replies is set by SetReplyBuffer.
+func (p *Connection) SetSignalPolicy(buffer int, policy SignalPolicy) {
+	p.signalLock.Lock()
+	p.signalBuffer = buffer
+	p.signalPolicy = policy
+	p.signalLock.Unlock()
+}
+
+// signalSender returns the function delivering signals to ch
+// according to the signal policy.
+func (p *Connection) signalSender(ch chan interface{}) func(interface{}) {
+	p.signalLock.Lock()
+	policy := p.signalPolicy
+	p.signalLock.Unlock()
+	switch policy {
+	case SignalDrop:
+		return func(v interface{}) {
+			select {
+			case ch <- v:
+			default:
+			}
+		}
+	case SignalGrow:
+		in := make(chan interface{})
+		go func() {
+			var queue []interface{}
+			for {
+				var out chan interface{}
+				var first interface{}
+				if len(queue) > 0 {
+					out, first = ch, queue[0]
+				}
+				select {
+				case v := <-in:
+					queue = append(queue, v)
+				case out <- first:
+					queue = queue[1:]
+				case <-p.signalsDone:
+					// No more signals: drop the queue.
+					return
+				}
+			}
+		}()
+		return func(v interface{}) { in <- v }
 	}
+	return func(v interface{}) { ch <- v }
+}
+
+// SubscribeTyped registers rule and returns a channel receiving the
+// matching signals, each decoded into a new value of the type of proto.
+// A signal carrying several values is decoded as a struct. Signals
+// which cannot be decoded are reported on the channel returned by
+// DecodeErrors.
+func (p *Connection) SubscribeTyped(rule *MatchRule, proto interface{}) (<-chan interface{}, error) {
+	typ := reflect.TypeOf(proto)
+	if typ == nil {
+		return nil, errors.New("nil prototype")
+	}
+	p.signalLock.Lock()
+	ch := make(chan interface{}, p.signalBuffer)
+	p.signalLock.Unlock()
+	send := p.signalSender(ch)
+	err := p.handle(rule, func(msg *Message) {
+		v := reflect.New(typ).Elem()
+		if err := msg.unmarshalValue(v); err != nil {
+			select {
+			case p.decodeErrs <- err:
+			default:
+			}
+			return
+		}
+		send(v.Interface())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// DecodeErrors returns a channel receiving the errors met while
+// decoding the signals delivered by SubscribeTyped. Errors are dropped
+// when the channel is full.
+func (p *Connection) DecodeErrors() <-chan error {
+	return p.decodeErrs
 }
@@ -1,9 +1,22 @@
 package dbus
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 type callTest struct {
@@ -26,11 +39,15 @@ var callTests = []callTest{
 }
 
 func testCall(c *Connection, t *testing.T, test callTest) {
-	method, err := c.Object(test.dest, test.path).
-		Interface(test.iface).
-		Method(test.method)
+	iface, err := c.Object(test.dest, test.path).Interface(test.iface)
 	if err != nil {
 		t.Error(err)
+		return
+	}
+	method, err := iface.Method(test.method)
+	if err != nil {
+		t.Error(err)
+		return
 	}
 	out, err := c.Call(method, test.args...)
 	if err != nil {
@@ -65,11 +82,14 @@ func ExampleConnection_Call(t *testing.T) {
 		log.Fatal(err)
 	}
 	conn.Authenticate()
-	method, err := conn.
+	iface, err := conn.
 		Object("org.freedesktop.DBus", "/org/freedesktop/DBus").
 		//Object("org.freedesktop.systemd1", "/org/freedesktop/systemd1").
-		Interface("org.freedesktop.DBus.Introspectable").
-		Method("Introspect")
+		Interface("org.freedesktop.DBus.Introspectable")
+	if err != nil {
+		log.Fatal(err)
+	}
+	method, err := iface.Method("Introspect")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -82,3 +102,1243 @@ func ExampleConnection_Call(t *testing.T) {
 	reply.Unmarshal(&data)
 	fmt.Println(data)
 }
+
+func TestObjectInterface(t *testing.T) {
+	intro, err := NewIntrospect(introStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nilObj *Object
+	if _, err = nilObj.Interface("org.freedesktop.SampleInterface"); err != errNilObject {
+		t.Errorf("nil object: got error %v, want %v", err, errNilObject)
+	}
+	obj := &Object{dest: "org.freedesktop.Sample", path: "/org/freedesktop/sample_object"}
+	if _, err = obj.Interface("org.freedesktop.SampleInterface"); err != errNoIntrospection {
+		t.Errorf("no introspection: got error %v, want %v", err, errNoIntrospection)
+	}
+	obj.intro = intro
+	_, err = obj.Interface("org.freedesktop.Unknown")
+	if err != errUnknownInterface("org.freedesktop.Unknown") {
+		t.Errorf("unknown interface: got error %v", err)
+	}
+	iface, err := obj.Interface("org.freedesktop.SampleInterface")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = iface.Method("Frobate"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestObjectNotIntrospectable(t *testing.T) {
+	conn := newTestConnection(func(msg *Message) *Message {
+		return newTestError(msg, "org.freedesktop.DBus.Error.UnknownInterface", "no introspection here")
+	})
+	obj := conn.Object("org.example.Service", "/org/example")
+	_, err := obj.Interface("org.example.Service")
+	e, ok := err.(errNotIntrospectable)
+	if !ok {
+		t.Fatalf("got error %v, want errNotIntrospectable", err)
+	}
+	if dbusErr, ok := e.E.(*DBusError); !ok || dbusErr.Name != "org.freedesktop.DBus.Error.UnknownInterface" {
+		t.Errorf("got underlying error %v", e.E)
+	}
+}
+
+func TestObjectErr(t *testing.T) {
+	conn := newTestConnection(func(msg *Message) *Message {
+		return newTestError(msg, "org.freedesktop.DBus.Error.UnknownObject", "no such object")
+	})
+	obj, err := conn.ObjectErr("org.example.Service", "/org/example")
+	if obj == nil {
+		t.Fatal("got a nil object")
+	}
+	e, ok := err.(errNotIntrospectable)
+	if !ok {
+		t.Fatalf("got error %v, want errNotIntrospectable", err)
+	}
+	if dbusErr, ok := e.E.(*DBusError); !ok || dbusErr.Name != "org.freedesktop.DBus.Error.UnknownObject" {
+		t.Errorf("got underlying error %v", e.E)
+	}
+
+	conn = newTestConnection(func(msg *Message) *Message {
+		return newTestReply(msg, "s", dbusXMLIntro)
+	})
+	if _, err = conn.ObjectErr("org.freedesktop.DBus", "/org/freedesktop/DBus"); err != nil {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestDBusErrorArgs(t *testing.T) {
+	conn := newTestConnection(func(msg *Message) *Message {
+		reply := newTestError(msg, "org.example.Error.Busy", "busy")
+		reply.Sig = "si"
+		reply.Params = append(reply.Params, int32(-3))
+		return reply
+	})
+	method := newTestMethod(t, `<node><interface name="org.example.Service">
+		<method name="Frobate"/>
+		</interface></node>`, "org.example.Service", "Frobate")
+
+	_, err := conn.Call(method)
+	e, ok := err.(*DBusError)
+	if !ok {
+		t.Fatalf("got error %v, want a *DBusError", err)
+	}
+	if e.Message != "busy" || !reflect.DeepEqual(e.Args, []interface{}{"busy", int32(-3)}) {
+		t.Errorf("got message %q and arguments %v", e.Message, e.Args)
+	}
+}
+
+func TestCallTyped(t *testing.T) {
+	calls := 0
+	conn := newTestConnection(func(msg *Message) *Message {
+		calls++
+		if msg.Member == "GetNameOwner" && msg.Params[0] == "org.example" {
+			return newTestReply(msg, "s", ":1.5")
+		}
+		return newTestReply(msg, "u", uint32(0))
+	})
+	out, err := conn.proxy.CallTyped("GetNameOwner", "org.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || out[0] != ":1.5" {
+		t.Errorf("got %v, want [:1.5]", out)
+	}
+
+	// Invalid arguments are not sent.
+	calls = 0
+	if _, err = conn.proxy.CallTyped("GetNameOwner", uint32(1)); err == nil {
+		t.Error("expected an error for a uint32 argument")
+	}
+	if _, err = conn.proxy.CallTyped("GetNameOwner"); err == nil {
+		t.Error("expected an error for a missing argument")
+	}
+	if calls != 0 {
+		t.Errorf("%d invalid calls were sent", calls)
+	}
+
+	// The reply must match the out signature.
+	if _, err = conn.proxy.CallTyped("GetNameOwner", "org.other"); err == nil {
+		t.Error("expected an error for a reply of signature u")
+	}
+}
+
+func TestAutoStart(t *testing.T) {
+	started := false
+	var calls []string
+	conn := newTestConnection(func(msg *Message) *Message {
+		calls = append(calls, msg.Member)
+		switch {
+		case msg.Member == "StartServiceByName":
+			if msg.Params[0] == "org.example.Service" {
+				started = true
+			}
+			return newTestReply(msg, "u", uint32(1))
+		case msg.Dest == "org.example.Service" && !started:
+			return newTestError(msg, errNameServiceUnknown, "not running")
+		}
+		return newTestReply(msg, "s", "pong")
+	})
+	method := newTestMethod(t, `<node><interface name="org.example.Service">
+		<method name="Ping"><arg direction="out" type="s"/></method>
+		</interface></node>`, "org.example.Service", "Ping")
+
+	// Without auto-start the error is returned.
+	if _, err := conn.Call(method); err == nil {
+		t.Fatal("expected a ServiceUnknown error")
+	}
+
+	conn.SetAutoStart(true)
+	calls = nil
+	out, err := conn.Call(method)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || out[0] != "pong" {
+		t.Errorf("got %v, want [pong]", out)
+	}
+	if want := []string{"Ping", "StartServiceByName", "Ping"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("got calls %v, want %v", calls, want)
+	}
+}
+
+func TestConnectionErr(t *testing.T) {
+	cli, srv := net.Pipe()
+	conn := &Connection{conn: cli}
+	conn.init()
+	conn.start()
+	srv.Close()
+	select {
+	case err := <-conn.Err():
+		if err != io.EOF {
+			t.Errorf("got error %v, want %v", err, io.EOF)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the connection error")
+	}
+	if _, ok := <-conn.Err(); ok {
+		t.Error("error channel was not closed")
+	}
+}
+
+func TestPopMessageFieldsSize(t *testing.T) {
+	// A signal with an empty body claiming 4GB of header fields.
+	header := "l\x04\x01\x01\x00\x00\x00\x00\x01\x00\x00\x00\xf0\xff\xff\xff"
+	r := bufio.NewReader(strings.NewReader(header))
+	_, _, err := popMessage(r)
+	if err != errFieldsSize(0xfffffff0) {
+		t.Errorf("got error %v, want errFieldsSize", err)
+	}
+
+	// A signal with no header fields claiming a 4GB body.
+	header = "l\x04\x01\x01\xf0\xff\xff\xff\x01\x00\x00\x00\x00\x00\x00\x00"
+	r = bufio.NewReader(strings.NewReader(header))
+	_, _, err = popMessage(r)
+	if err != errMessageSize(16+0xfffffff0) {
+		t.Errorf("got error %v, want errMessageSize", err)
+	}
+}
+
+func TestHeaderLayout(t *testing.T) {
+	info, err := HeaderLayout([]byte(helloFrame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := HeaderInfo{FieldsLength: 109, Padding: 3, BodyOffset: 128, BodyLength: 0, ByteOrder: binary.LittleEndian}
+	if info != want {
+		t.Errorf("got %+v, want %+v", info, want)
+	}
+	if info.BodyOffset != len(helloFrame) {
+		t.Errorf("body offset %d, message length %d", info.BodyOffset, len(helloFrame))
+	}
+
+	if _, err = HeaderLayout([]byte(helloFrame[:10])); err == nil {
+		t.Error("expected an error for a truncated header")
+	}
+}
+
+func TestReplySignatureMismatch(t *testing.T) {
+	conn := newTestConnection(func(msg *Message) *Message {
+		return newTestReply(msg, "u", uint32(42))
+	})
+	method := newTestMethod(t, `<node><interface name="org.example.Service">
+		<method name="Get"><arg direction="out" type="s"/></method>
+		</interface></node>`, "org.example.Service", "Get")
+
+	var logbuf bytes.Buffer
+	log.SetOutput(&logbuf)
+	defer log.SetOutput(os.Stderr)
+
+	out, err := conn.Call(method)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header signature wins.
+	if len(out) != 1 || out[0] != uint32(42) {
+		t.Errorf("got %v, want [42]", out)
+	}
+	if !strings.Contains(logbuf.String(), `reply signature "u" differs from introspected signature "s"`) {
+		t.Errorf("missing warning, got log %q", logbuf.String())
+	}
+}
+
+func TestFlush(t *testing.T) {
+	signals := make(chan *Message, 1)
+	conn := newTestConnection(func(msg *Message) *Message {
+		if msg.Type == TypeSignal {
+			signals <- msg
+			return nil
+		}
+		return newTestReply(msg, "")
+	})
+	obj := newTestObject(t, `<node><interface name="org.example.Service">
+		<signal name="Changed"><arg type="s"/></signal>
+		</interface></node>`)
+	iface, _ := obj.Interface("org.example.Service")
+	signal, err := iface.Signal("Changed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Emit(signal, "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-signals:
+		if msg.Member != "Changed" || msg.Sig != "s" {
+			t.Errorf("got signal %s with signature %q", msg.Member, msg.Sig)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("emitted signal not received after Flush")
+	}
+}
+
+func TestSignalMatchRule(t *testing.T) {
+	obj := newTestObject(t, `<node><interface name="org.example.Service">
+		<signal name="Changed"><arg type="s"/></signal>
+		</interface></node>`)
+	iface, _ := obj.Interface("org.example.Service")
+	signal, err := iface.Signal("Changed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "type='signal',interface='org.example.Service',member='Changed',path='/org/example'"
+	if got := signal.MatchRule().String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestCallWithReplySig(t *testing.T) {
+	conn := newTestConnection(func(msg *Message) *Message {
+		// A reply without signature header field.
+		reply := newTestReply(msg, "")
+		reply.SetRawBody("", []byte{7, 0, 0, 0})
+		return reply
+	})
+	method := newTestMethod(t, `<node><interface name="org.example.Service">
+		<method name="Count"><arg direction="out" type="u"/></method>
+		</interface></node>`, "org.example.Service", "Count")
+
+	if _, err := conn.Call(method); err == nil {
+		t.Error("Call: expected an error for a body without signature")
+	}
+	out, err := conn.CallWithReplySig(method, "u")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || out[0] != uint32(7) {
+		t.Errorf("got %#v, want [7]", out)
+	}
+}
+
+func TestCallSig(t *testing.T) {
+	calls := make(chan *Message, 1)
+	conn := newTestConnection(func(msg *Message) *Message {
+		calls <- msg
+		return newTestReply(msg, "")
+	})
+	method := newTestMethod(t, `<node><interface name="org.example.Service">
+		<method name="Set"><arg direction="in" type="s"/></method>
+		</interface></node>`, "org.example.Service", "Set")
+
+	if _, err := conn.CallSig(method, "v", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-calls
+	if msg.Sig != "v" {
+		t.Errorf("call sent with signature %q, want \"v\"", msg.Sig)
+	}
+	// A string variant: signature "s" then the string.
+	if want := "\x01s\x00\x00\x05\x00\x00\x00hello\x00"; string(msg.Body()) != want {
+		t.Errorf("got body %q, want %q", msg.Body(), want)
+	}
+}
+
+func TestCallArgumentType(t *testing.T) {
+	conn := newTestConnection(func(msg *Message) *Message {
+		return newTestReply(msg, "")
+	})
+	method := newTestMethod(t, `<node><interface name="org.example.Service">
+		<method name="Set"><arg direction="in" type="u"/></method>
+		</interface></node>`, "org.example.Service", "Set")
+
+	if _, err := conn.Call(method, 1); err == nil {
+		t.Error("expected an error for an int argument of type u")
+	}
+	if _, err := conn.Call(method, uint32(1)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUse(t *testing.T) {
+	calls := make(chan *Message, 2)
+	conn := newTestConnection(func(msg *Message) *Message {
+		calls <- msg
+		return newTestReply(msg, "")
+	})
+	var order []string
+	conn.Use(func(msg *Message) error {
+		order = append(order, "sender")
+		msg.Sender = ":1.42"
+		return nil
+	})
+	errForbidden := errors.New("forbidden")
+	conn.Use(func(msg *Message) error {
+		order = append(order, "filter")
+		if msg.Member == "Forbidden" {
+			return errForbidden
+		}
+		return nil
+	})
+	obj := newTestObject(t, `<node><interface name="org.example.Service">
+		<method name="Allowed"/><method name="Forbidden"/>
+		</interface></node>`)
+	iface, _ := obj.Interface("org.example.Service")
+
+	method, _ := iface.Method("Allowed")
+	if _, err := conn.Call(method); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-calls; msg.Sender != ":1.42" {
+		t.Errorf("call sent with sender %q, want :1.42", msg.Sender)
+	}
+	if !reflect.DeepEqual(order, []string{"sender", "filter"}) {
+		t.Errorf("middlewares ran as %v", order)
+	}
+
+	method, _ = iface.Method("Forbidden")
+	if _, err := conn.Call(method); err != errForbidden {
+		t.Errorf("got error %v, want %v", err, errForbidden)
+	}
+	select {
+	case msg := <-calls:
+		t.Errorf("rejected call %s was sent", msg.Member)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCallNoAutoStart(t *testing.T) {
+	calls := make(chan *Message, 2)
+	conn := newTestConnection(func(msg *Message) *Message {
+		calls <- msg
+		return newTestError(msg, errNameServiceUnknown, "not running")
+	})
+	conn.SetAutoStart(true)
+	method := newTestMethod(t, `<node><interface name="org.example.Service">
+		<method name="Ping"/>
+		</interface></node>`, "org.example.Service", "Ping")
+
+	_, err := conn.CallNoAutoStart(method)
+	if e, ok := err.(*DBusError); !ok || e.Name != errNameServiceUnknown {
+		t.Errorf("got error %v, want ServiceUnknown", err)
+	}
+	// The bus decoded the flags from the header.
+	msg := <-calls
+	if msg.Flags&FlagNoAutoStart == 0 {
+		t.Errorf("flags are %#x, want bit 1 set", msg.Flags)
+	}
+	select {
+	case msg = <-calls:
+		t.Errorf("unexpected %s call after ServiceUnknown", msg.Member)
+	default:
+	}
+}
+
+func TestSignalSender(t *testing.T) {
+	signals := make(chan *Message, 2)
+	conn := newTestConnection(func(msg *Message) *Message {
+		switch msg.Type {
+		case TypeSignal:
+			signals <- msg
+			return nil
+		}
+		if msg.Member == "Hello" {
+			return newTestReply(msg, "s", ":1.42")
+		}
+		return newTestReply(msg, "")
+	})
+	if err := conn._SendHello(); err != nil {
+		t.Fatal(err)
+	}
+	if name := conn.UniqueName(); name != ":1.42" {
+		t.Fatalf("got unique name %q, want :1.42", name)
+	}
+
+	for _, set := range []bool{false, true} {
+		conn.SetSignalSender(set)
+		if err := conn.EmitSignalTo("", "/org/example", "org.example", "Changed", ""); err != nil {
+			t.Fatal(err)
+		}
+		want := ""
+		if set {
+			want = ":1.42"
+		}
+		select {
+		case msg := <-signals:
+			if msg.Sender != want {
+				t.Errorf("SetSignalSender(%v): got sender %q, want %q", set, msg.Sender, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("signal not received")
+		}
+	}
+}
+
+func TestGetProperty(t *testing.T) {
+	props := map[string]interface{}{
+		"Name":    "example",
+		"Count":   uint32(3),
+		"Aliases": []string{"a", "b"},
+	}
+	conn := newTestConnection(func(msg *Message) *Message {
+		if msg.Iface != "org.freedesktop.DBus.Properties" || msg.Member != "Get" {
+			return newTestError(msg, "org.freedesktop.DBus.Error.UnknownMethod", msg.Member)
+		}
+		v, ok := props[msg.Params[1].(string)]
+		if !ok || msg.Params[0] != "org.example" {
+			return newTestError(msg, "org.freedesktop.DBus.Error.UnknownProperty", "no such property")
+		}
+		return newTestReply(msg, "v", v)
+	})
+	obj := conn.Object("org.example.Service", "/org/example")
+	for name, want := range props {
+		got, err := obj.GetProperty("org.example", name)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %#v, want %#v", name, got, want)
+		}
+	}
+	if _, err := obj.GetProperty("org.example", "Missing"); err == nil {
+		t.Error("expected an error for a missing property")
+	}
+}
+
+func TestSetProperty(t *testing.T) {
+	calls := make(chan *Message, 1)
+	conn := newTestConnection(func(msg *Message) *Message {
+		if msg.Member == "Set" {
+			calls <- msg
+		}
+		return newTestReply(msg, "")
+	})
+	obj := newTestObject(t, `<node><interface name="org.example">
+		<property name="Count" type="u" access="readwrite"/>
+		</interface></node>`)
+	obj.conn = conn
+
+	if err := obj.SetProperty("org.example", "Count", uint32(3)); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-calls
+	if msg.Iface != "org.freedesktop.DBus.Properties" || msg.Sig != "ssv" {
+		t.Errorf("bad Set call: %+v", msg)
+	}
+	body := &msgData{ByteOrder: binary.LittleEndian, Data: msg.Body()}
+	var iface, name string
+	var sig Signature
+	if err := body.scan("s", &iface); err != nil {
+		t.Fatal(err)
+	}
+	if err := body.scan("s", &name); err != nil {
+		t.Fatal(err)
+	}
+	if err := body.scan("g", &sig); err != nil {
+		t.Fatal(err)
+	}
+	if iface != "org.example" || name != "Count" || sig != "u" {
+		t.Errorf("got %s.%s with variant signature %q, want \"u\"", iface, name, sig)
+	}
+	if !reflect.DeepEqual(msg.Params[2], uint32(3)) {
+		t.Errorf("got value %#v", msg.Params[2])
+	}
+
+	// The value must match the introspected type.
+	if err := obj.SetProperty("org.example", "Count", int32(3)); err == nil {
+		t.Error("expected an error for an int32 value")
+	}
+}
+
+func TestEmitSignalTo(t *testing.T) {
+	signals := make(chan *Message, 1)
+	conn := newTestConnection(func(msg *Message) *Message {
+		if msg.Type == TypeSignal {
+			signals <- msg
+		}
+		return nil
+	})
+	err := conn.EmitSignalTo(":1.7", "/org/example", "org.example", "Notify", "su", "hello", uint32(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-signals:
+		if msg.Dest != ":1.7" || msg.Member != "Notify" || !reflect.DeepEqual(msg.Params, []interface{}{"hello", uint32(3)}) {
+			t.Errorf("got signal %s to %q with %v", msg.Member, msg.Dest, msg.Params)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal not received")
+	}
+}
+
+func TestIsConnected(t *testing.T) {
+	conn := newTestConnection(func(msg *Message) *Message {
+		return newTestReply(msg, "")
+	})
+	if !conn.IsConnected() {
+		t.Error("IsConnected is false on a new connection")
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if conn.IsConnected() {
+		t.Error("IsConnected is true after Close")
+	}
+	<-conn.Err()
+	if conn.IsConnected() {
+		t.Error("IsConnected is true after the dispatch loop stopped")
+	}
+}
+
+func TestCloseRemovesMatches(t *testing.T) {
+	removed := make(chan string, 2)
+	conn := newTestConnection(func(msg *Message) *Message {
+		if msg.Member == "RemoveMatch" {
+			if msg.Flags&FlagNoReplyExpected == 0 {
+				t.Error("RemoveMatch expects a reply")
+			}
+			removed <- msg.Params[0].(string)
+			return nil
+		}
+		return newTestReply(msg, "")
+	})
+	rules := []*MatchRule{
+		{Type: TypeSignal, Interface: "org.example.A"},
+		{Type: TypeSignal, Interface: "org.example.B", Member: "Changed"},
+	}
+	for _, rule := range rules {
+		conn.Handle(rule, func(*Message) {})
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, rule := range rules {
+		select {
+		case s := <-removed:
+			if s != rule.String() {
+				t.Errorf("got RemoveMatch(%q), want %q", s, rule.String())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no RemoveMatch call for %q", rule.String())
+		}
+	}
+}
+
+type nameChange struct {
+	Name, OldOwner, NewOwner string
+}
+
+func TestSubscribeTyped(t *testing.T) {
+	conn, bus := newTestBus(func(msg *Message) *Message {
+		return newTestReply(msg, "")
+	})
+	rule := &MatchRule{Type: TypeSignal, Interface: "org.example", Member: "NameOwnerChanged"}
+	ch, err := conn.SubscribeTyped(rule, nameChange{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendTestMessage(t, bus, newTestSignal("org.example", "NameOwnerChanged", "u", uint32(1)))
+	sendTestMessage(t, bus, newTestSignal("org.example", "NameOwnerChanged", "sss", "org.example.A", "", ":1.2"))
+	select {
+	case v := <-ch:
+		want := nameChange{"org.example.A", "", ":1.2"}
+		if v != want {
+			t.Errorf("got %#v, want %#v", v, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for signal")
+	}
+	select {
+	case err := <-conn.DecodeErrors():
+		t.Logf("decode error: %s", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no decode error for a mismatched signal")
+	}
+}
+
+func TestSubscribeTypedError(t *testing.T) {
+	conn := newTestConnection(func(msg *Message) *Message {
+		return newTestError(msg, "org.freedesktop.DBus.Error.MatchRuleInvalid", "invalid")
+	})
+	rule := &MatchRule{Type: TypeSignal, Interface: "org.example", Member: "Changed"}
+	if _, err := conn.SubscribeTyped(rule, ""); err == nil {
+		t.Fatal("expected the AddMatch error")
+	}
+	conn.signalLock.Lock()
+	n := len(conn.signalMatchRules)
+	conn.signalLock.Unlock()
+	if n != 0 {
+		t.Errorf("%d handlers left after a failed subscription", n)
+	}
+}
+
+func TestSignalPolicyDrop(t *testing.T) {
+	conn, bus := newTestBus(func(msg *Message) *Message {
+		return newTestReply(msg, "")
+	})
+	conn.SetSignalPolicy(1, SignalDrop)
+	rule := &MatchRule{Type: TypeSignal, Interface: "org.example", Member: "Count"}
+	ch, err := conn.SubscribeTyped(rule, uint32(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Signals are delivered in order: the Done signal
+	// is handled after the others.
+	done := make(chan struct{})
+	conn.Handle(&MatchRule{Type: TypeSignal, Interface: "org.example", Member: "Done"},
+		func(*Message) { close(done) })
+	for i := uint32(1); i <= 3; i++ {
+		sendTestMessage(t, bus, newTestSignal("org.example", "Count", "u", i))
+	}
+	sendTestMessage(t, bus, newTestSignal("org.example", "Done", ""))
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for signals")
+	}
+	if len(ch) != 1 {
+		t.Fatalf("got %d queued signals, want 1", len(ch))
+	}
+	if v := <-ch; v != uint32(1) {
+		t.Errorf("got %v, want the first signal", v)
+	}
+}
+
+func TestObjectTimeout(t *testing.T) {
+	conn := newTestConnection(func(msg *Message) *Message {
+		if msg.Member == "Slow" {
+			// Never answer.
+			return nil
+		}
+		return newTestReply(msg, "")
+	})
+	conn.SetCallTimeout(5 * time.Second)
+	method := newTestMethod(t, `<node><interface name="org.example">
+		<method name="Slow"/>
+		</interface></node>`, "org.example", "Slow")
+	method.iface.obj.SetTimeout(20 * time.Millisecond)
+
+	start := time.Now()
+	if _, err := conn.Call(method); err != errCallTimeout {
+		t.Fatalf("got error %v, want %v", err, errCallTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("call timed out after %s, want the object timeout", elapsed)
+	}
+	conn.replyLock.Lock()
+	pending := len(conn.replyChans)
+	conn.replyLock.Unlock()
+	if pending != 0 {
+		t.Errorf("%d reply channels left after timeout", pending)
+	}
+}
+
+func TestSignalDuringCall(t *testing.T) {
+	release := make(chan struct{})
+	handled := make(chan *Message, 1)
+	var conn *Connection
+	var bus net.Conn
+	conn, bus = newTestBus(func(msg *Message) *Message {
+		if msg.Member == "Frobate" {
+			// A signal arrives before the reply.
+			b, _ := newTestSignal("org.example", "Changed", "u", uint32(1))._Marshal()
+			bus.Write(b)
+		}
+		return newTestReply(msg, "")
+	})
+	conn.Handle(&MatchRule{Type: TypeSignal, Interface: "org.example", Member: "Changed"},
+		func(msg *Message) {
+			// Block until the call returned.
+			<-release
+			handled <- msg
+		})
+
+	method := newTestMethod(t, `<node><interface name="org.example">
+		<method name="Frobate"/>
+		</interface></node>`, "org.example", "Frobate")
+	errs := make(chan error, 1)
+	go func() {
+		_, err := conn.Call(method)
+		errs <- err
+	}()
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("call blocked by the signal handler")
+	}
+	close(release)
+	select {
+	case msg := <-handled:
+		if msg.Member != "Changed" {
+			t.Errorf("got signal %s", msg.Member)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal not handled")
+	}
+}
+
+func TestSignalPolicyGrow(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	conn, bus := newTestBus(func(msg *Message) *Message {
+		return newTestReply(msg, "")
+	})
+	conn.SetSignalPolicy(0, SignalGrow)
+	rule := &MatchRule{Type: TypeSignal, Interface: "org.example", Member: "Count"}
+	ch, err := conn.SubscribeTyped(rule, uint32(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(1); i <= 3; i++ {
+		sendTestMessage(t, bus, newTestSignal("org.example", "Count", "u", i))
+	}
+	if err := conn.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(1); i <= 3; i++ {
+		select {
+		case v := <-ch:
+			if v != i {
+				t.Errorf("got %v, want %d", v, i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for signal")
+		}
+	}
+
+	// The queueing goroutine stops with the connection.
+	conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > goroutines {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left running, want %d", runtime.NumGoroutine(), goroutines)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReplyBuffer(t *testing.T) {
+	conn := newTestConnection(func(msg *Message) *Message {
+		return newTestReply(msg, "u", uint32(7))
+	})
+	conn.SetReplyBuffer(0)
+	for i := 0; i < 3; i++ {
+		if err := conn.ReloadConfig(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConnectContext(t *testing.T) {
+	sock := t.TempDir() + "/bus"
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path="+sock)
+
+	// A cancelled context aborts dialing.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = ConnectContext(ctx, SessionBus); err == nil {
+		t.Fatal("expected an error from a cancelled context")
+	}
+
+	// The listener accepts connections but never answers:
+	// cancelling the context aborts authentication.
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	conn, err := ConnectContext(context.Background(), SessionBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = conn.AuthenticateContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestConnectAddressWithEquals(t *testing.T) {
+	sock := t.TempDir() + "/bus=1"
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path="+sock+",guid=0123")
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+
+	conn, err := Connect(SessionBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.addressMap["path"] != sock || conn.addressMap["guid"] != "0123" {
+		t.Errorf("got address %v", conn.addressMap)
+	}
+}
+
+func TestConn(t *testing.T) {
+	sock := t.TempDir() + "/bus"
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path="+sock)
+
+	conn, err := Connect(SessionBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c, ok := conn.Conn().(*net.UnixConn)
+	if !ok {
+		t.Fatalf("got %T, want a *net.UnixConn", conn.Conn())
+	}
+	if addr := c.RemoteAddr().String(); addr != sock {
+		t.Errorf("connection is to %s, want %s", addr, sock)
+	}
+}
+
+func TestSystemBusPath(t *testing.T) {
+	dir := t.TempDir()
+	l, err := net.Listen("unix", dir+"/system_bus_socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	t.Setenv("DBUS_SYSTEM_BUS_ADDRESS", "")
+	defer func(path, run string) {
+		SystemBusPath, runSystemBusPath = path, run
+	}(SystemBusPath, runSystemBusPath)
+
+	// The configured path.
+	SystemBusPath = dir + "/system_bus_socket"
+	runSystemBusPath = dir + "/missing"
+	conn, err := Connect(SystemBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// The fallback path, when the configured one does not exist.
+	SystemBusPath = dir + "/missing"
+	runSystemBusPath = dir + "/system_bus_socket"
+	conn, err = Connect(SystemBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestAuthTimeout(t *testing.T) {
+	cli, srv := net.Pipe()
+	defer srv.Close()
+	// The peer reads the handshake but never answers.
+	go io.Copy(io.Discard, srv)
+	conn := &Connection{conn: cli}
+	conn.init()
+	conn.SetAuthTimeout(50 * time.Millisecond)
+	start := time.Now()
+	if err := conn.Authenticate(); err != errAuthTimeout {
+		t.Errorf("got error %v, want %v", err, errAuthTimeout)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("authentication took %s", d)
+	}
+}
+
+// newTestConnection returns a connection talking to a fake bus
+// which answers each received message with handler.
+func newTestConnection(handler func(msg *Message) *Message) *Connection {
+	conn, _ := newTestBus(handler)
+	return conn
+}
+
+// newTestBus is like newTestConnection but also returns the bus end
+// of the connection, to send unsolicited messages.
+func newTestBus(handler func(msg *Message) *Message) (*Connection, net.Conn) {
+	cli, srv := net.Pipe()
+	conn := &Connection{conn: cli}
+	conn.init()
+	go serveTestBus(srv, handler)
+	conn.start()
+	return conn, srv
+}
+
+// newTestObject returns the object /org/example of the service
+// org.example.Service, described by the introspection data xml.
+func newTestObject(t testing.TB, xml string) *Object {
+	intro, err := NewIntrospect(xml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Object{dest: "org.example.Service", path: "/org/example", intro: intro}
+}
+
+// newTestMethod returns the method member of interface iface of the
+// test object described by xml.
+func newTestMethod(t testing.TB, xml, iface, member string) *Method {
+	i, err := newTestObject(t, xml).Interface(iface)
+	if err != nil {
+		t.Fatal(err)
+	}
+	method, err := i.Method(member)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return method
+}
+
+// newTestSignal builds a signal message.
+func newTestSignal(iface, member, sig string, params ...interface{}) *Message {
+	msg := NewMessage()
+	msg.Type = TypeSignal
+	msg.Path = "/org/example"
+	msg.Iface = iface
+	msg.Member = member
+	msg.Sig = sig
+	msg.Params = params
+	return msg
+}
+
+func sendTestMessage(t *testing.T, conn net.Conn, msg *Message) {
+	b, err := msg._Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Write(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func serveTestBus(conn net.Conn, handler func(msg *Message) *Message) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		raw, _, err := popMessage(r)
+		if err != nil {
+			return
+		}
+		msg, err := newRawMessage(raw)
+		if err != nil {
+			return
+		}
+		msg.parseParams()
+		reply := handler(msg)
+		if reply == nil {
+			continue
+		}
+		b, err := reply._Marshal()
+		if err != nil {
+			return
+		}
+		if _, err = conn.Write(b); err != nil {
+			return
+		}
+	}
+}
+
+// newTestReply builds a method return for call.
+func newTestReply(call *Message, sig string, params ...interface{}) *Message {
+	reply := NewMessage()
+	reply.Type = TypeMethodReturn
+	reply.replySerial = call.serial
+	reply.Sig = sig
+	reply.Params = params
+	return reply
+}
+
+// newTestError builds an error reply for call.
+func newTestError(call *Message, name, text string) *Message {
+	reply := NewMessage()
+	reply.Type = TypeError
+	reply.replySerial = call.serial
+	reply.ErrorName = name
+	reply.Sig = "s"
+	reply.Params = []interface{}{text}
+	return reply
+}
+
+// newSocketPair returns two connected unix sockets.
+func newSocketPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conns [2]*net.UnixConn
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		c, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns[i] = c.(*net.UnixConn)
+	}
+	return conns[0], conns[1]
+}
+
+func TestConnectFromFD(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := os.NewFile(uintptr(fds[1]), "server")
+	defer srv.Close()
+	conn, err := ConnectFromFD(fds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, ok := conn.Conn().(*net.UnixConn); !ok {
+		t.Errorf("got %T, want a unix socket", conn.Conn())
+	}
+	// The credentials byte precedes authentication.
+	var b [1]byte
+	if _, err = srv.Read(b[:]); err != nil || b[0] != 0 {
+		t.Errorf("got %q, %v, want a NUL byte", b[:], err)
+	}
+}
+
+func TestListenFDs(t *testing.T) {
+	t.Setenv("LISTEN_PID", fmt.Sprint(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "2")
+	fds, err := ListenFDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fds, []int{3, 4}) {
+		t.Errorf("got %v, want [3 4]", fds)
+	}
+
+	t.Setenv("LISTEN_PID", fmt.Sprint(os.Getpid()+1))
+	if fds, err = ListenFDs(); err != nil || fds != nil {
+		t.Errorf("got %v, %v for another process", fds, err)
+	}
+}
+
+func TestReceiveFd(t *testing.T) {
+	cli, srv := newSocketPair(t)
+	defer srv.Close()
+	conn := &Connection{conn: cli}
+	conn.init()
+	received := make(chan *Message, 1)
+	conn.signalMatchRules = append(conn.signalMatchRules, &signalHandler{
+		MatchRule{Type: TypeSignal},
+		func(msg *Message) { received <- msg }})
+	conn.start()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	w.Write([]byte("hello"))
+	w.Close()
+
+	sig := newTestSignal("org.example", "Fd", "h", uint32(0))
+	sig.Fds = []int{int(r.Fd())}
+	b, err := sig._Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = srv.WriteMsgUnix(b, syscall.UnixRights(int(r.Fd())), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := <-received
+	if len(msg.Fds) != 1 {
+		t.Fatalf("got %d file descriptors, want 1", len(msg.Fds))
+	}
+	f, ok := msg.Params[0].(*os.File)
+	if !ok {
+		t.Fatalf("got %T, want *os.File", msg.Params[0])
+	}
+	defer f.Close()
+	// Decoding the message again yields the same file.
+	var f2 *os.File
+	if err := msg.Unmarshal(&f2); err != nil {
+		t.Fatal(err)
+	}
+	if f2 != f {
+		t.Error("decoding the message again created another file")
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("read %q from passed file descriptor, want %q", data, "hello")
+	}
+}
+
+func TestReceiveFdBadMessage(t *testing.T) {
+	cli, srv := newSocketPair(t)
+	defer srv.Close()
+	conn := &Connection{conn: cli}
+	conn.init()
+	conn.SetStrictHeaders(true)
+	SetLogger(nil)
+	defer SetLogger(log.Default())
+	received := make(chan *Message, 1)
+	conn.signalMatchRules = append(conn.signalMatchRules, &signalHandler{
+		MatchRule{Type: TypeSignal},
+		func(msg *Message) { received <- msg }})
+	conn.start()
+
+	send := func(b []byte, contents string) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		w.Write([]byte(contents))
+		w.Close()
+		if _, _, err = srv.WriteMsgUnix(b, syscall.UnixRights(int(r.Fd())), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A signal passing a file descriptor, with the unknown header
+	// field 10 rejected in strict mode.
+	send([]byte("l\x04\x00\x01\x00\x00\x00\x00\x01\x00\x00\x00\x3d\x00\x00\x00"+
+		"\x01\x01o\x00\x02\x00\x00\x00/a\x00\x00\x00\x00\x00\x00"+
+		"\x02\x01s\x00\x03\x00\x00\x00a.b\x00\x00\x00\x00\x00"+
+		"\x03\x01s\x00\x01\x00\x00\x00M\x00\x00\x00\x00\x00\x00\x00"+
+		"\x09\x01u\x00\x01\x00\x00\x00"+
+		"\x0a\x01y\x00\x07\x00\x00\x00"), "bad")
+	sig := newTestSignal("org.example", "Fd", "h", uint32(0))
+	sig.Fds = []int{0}
+	b, err := sig._Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(b, "hello")
+
+	msg := <-received
+	f, ok := msg.Params[0].(*os.File)
+	if !ok {
+		t.Fatalf("got %T, want *os.File", msg.Params[0])
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("read %q from passed file descriptor, want %q", data, "hello")
+	}
+}
@@ -0,0 +1,326 @@
+package dbus
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// An ExportedMethod is a method served by the connection to its peers.
+type ExportedMethod struct {
+	InSig  string
+	OutSig string
+	// Handler is called with the incoming call, whose Params are
+	// decoded, and returns the out arguments, matching OutSig.
+	Handler func(call *Message) ([]interface{}, error)
+}
+
+const (
+	errNameFailed         = "org.freedesktop.DBus.Error.Failed"
+	errNameUnknownMethod  = "org.freedesktop.DBus.Error.UnknownMethod"
+	errNameLimitsExceeded = "org.freedesktop.DBus.Error.LimitsExceeded"
+	errNameInvalidArgs    = "org.freedesktop.DBus.Error.InvalidArgs"
+)
+
+// maxCallHandlers is the maximum number of incoming method calls
+// handled concurrently. Further calls are rejected.
+const maxCallHandlers = 16
+
+// Export serves methods as interface iface of the object at path.
+// Exporting a nil map removes the interface. Calls to the
+// org.freedesktop.DBus.Peer and org.freedesktop.DBus.Introspectable
+// interfaces are answered by default, unless they are exported
+// explicitly: Introspect describes the interfaces exported at the
+// called path and lists the exported paths below it as child nodes.
+func (p *Connection) Export(path, iface string, methods map[string]ExportedMethod) {
+	p.exportLock.Lock()
+	defer p.exportLock.Unlock()
+	ifaces := p.exported[path]
+	if methods == nil {
+		delete(ifaces, iface)
+		if len(ifaces) == 0 {
+			delete(p.exported, path)
+		}
+		return
+	}
+	if ifaces == nil {
+		ifaces = make(map[string]map[string]ExportedMethod)
+		p.exported[path] = ifaces
+	}
+	ifaces[iface] = methods
+}
+
+// exportedMethod returns the method called by call. The interface
+// is optional in method calls.
+func (p *Connection) exportedMethod(call *Message) (ExportedMethod, bool) {
+	p.exportLock.Lock()
+	defer p.exportLock.Unlock()
+	if m, ok := findMethod(p.exported[call.Path], call.Iface, call.Member); ok {
+		return m, ok
+	}
+	m, ok := findMethod(defaultMethods, call.Iface, call.Member)
+	if ok && m.Handler == nil {
+		m.Handler = p.introspect
+	}
+	return m, ok
+}
+
+func findMethod(ifaces map[string]map[string]ExportedMethod, iface, member string) (ExportedMethod, bool) {
+	if iface != "" {
+		m, ok := ifaces[iface][member]
+		return m, ok
+	}
+	for _, methods := range ifaces {
+		if m, ok := methods[member]; ok {
+			return m, true
+		}
+	}
+	return ExportedMethod{}, false
+}
+
+// Methods answered for every object path.
+var defaultMethods = map[string]map[string]ExportedMethod{
+	"org.freedesktop.DBus.Peer": {
+		"Ping": {Handler: func(*Message) ([]interface{}, error) {
+			return nil, nil
+		}},
+		"GetMachineId": {OutSig: "s", Handler: func(*Message) ([]interface{}, error) {
+			id, err := machineId()
+			return []interface{}{id}, err
+		}},
+	},
+	"org.freedesktop.DBus.Introspectable": {
+		// The handler is Connection.introspect.
+		"Introspect": {OutSig: "s"},
+	},
+}
+
+func machineId() (string, error) {
+	var err error
+	for _, path := range []string{"/var/lib/dbus/machine-id", "/etc/machine-id"} {
+		var b []byte
+		if b, err = os.ReadFile(path); err == nil {
+			return strings.TrimSpace(string(b)), nil
+		}
+	}
+	return "", err
+}
+
+// introspect answers Introspect calls with the interfaces exported
+// at the called path, and its children in the tree of exported paths.
+func (p *Connection) introspect(call *Message) ([]interface{}, error) {
+	p.exportLock.Lock()
+	defer p.exportLock.Unlock()
+	ifaces := make(map[string]map[string]ExportedMethod)
+	for _, all := range []map[string]map[string]ExportedMethod{defaultMethods, p.exported[call.Path]} {
+		for iface, methods := range all {
+			if ifaces[iface] == nil {
+				ifaces[iface] = make(map[string]ExportedMethod)
+			}
+			for name, m := range methods {
+				ifaces[iface][name] = m
+			}
+		}
+	}
+	prefix := strings.TrimSuffix(call.Path, "/") + "/"
+	var children []string
+	for path := range p.exported {
+		if strings.HasPrefix(path, prefix) {
+			children = append(children, strings.SplitN(path[len(prefix):], "/", 2)[0])
+		}
+	}
+	return []interface{}{introspectXML(ifaces, children)}, nil
+}
+
+const introspectDoctype = `<!DOCTYPE node PUBLIC "-//freedesktop//DTD D-BUS Object Introspection 1.0//EN"
+"http://www.freedesktop.org/standards/dbus/1.0/introspect.dtd">
+`
+
+func introspectXML(ifaces map[string]map[string]ExportedMethod, children []string) string {
+	names := make([]string, 0, len(ifaces))
+	for iface := range ifaces {
+		names = append(names, iface)
+	}
+	sort.Strings(names)
+	sort.Strings(children)
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(introspectDoctype)
+	buf.WriteString("<node>\n")
+	for _, iface := range names {
+		fmt.Fprintf(buf, "  <interface name=\"%s\">\n", xmlEscape(iface))
+		methods := ifaces[iface]
+		members := make([]string, 0, len(methods))
+		for name := range methods {
+			members = append(members, name)
+		}
+		sort.Strings(members)
+		for _, name := range members {
+			m := methods[name]
+			fmt.Fprintf(buf, "    <method name=\"%s\">\n", xmlEscape(name))
+			writeArgs(buf, "in", m.InSig)
+			writeArgs(buf, "out", m.OutSig)
+			buf.WriteString("    </method>\n")
+		}
+		buf.WriteString("  </interface>\n")
+	}
+	for i, child := range children {
+		if i > 0 && child == children[i-1] {
+			continue
+		}
+		fmt.Fprintf(buf, "  <node name=\"%s\"/>\n", xmlEscape(child))
+	}
+	buf.WriteString("</node>\n")
+	return buf.String()
+}
+
+func writeArgs(buf *bytes.Buffer, direction, sig string) {
+	types, err := Signature(sig).Elements()
+	if err != nil {
+		types = []Signature{Signature(sig)}
+	}
+	for _, t := range types {
+		fmt.Fprintf(buf, "      <arg direction=\"%s\" type=\"%s\"/>\n", direction, xmlEscape(string(t)))
+	}
+}
+
+func xmlEscape(s string) string {
+	buf := new(bytes.Buffer)
+	xml.EscapeText(buf, []byte(s))
+	return buf.String()
+}
+
+// EmitPropertiesChanged broadcasts the PropertiesChanged signal of
+// org.freedesktop.DBus.Properties for the object at path, announcing
+// the new values of the changed properties of interface iface, and
+// the properties which changed without their value being sent. The
+// values are sent as variants, of the signature given by SignatureOf
+// unless they are a Variant.
+func (p *Connection) EmitPropertiesChanged(path, iface string, changed map[string]interface{}, invalidated []string) error {
+	names := make([]string, 0, len(changed))
+	for name := range changed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries := make([]interface{}, len(names))
+	for i, name := range names {
+		entries[i] = []interface{}{name, changed[name]}
+	}
+	return p.EmitSignalTo("", path, "org.freedesktop.DBus.Properties", "PropertiesChanged",
+		"sa{sv}as", iface, entries, invalidated)
+}
+
+// serveCall handles an incoming method call in its own goroutine, or
+// rejects it if maxCallHandlers calls are already being handled.
+func (p *Connection) serveCall(call *Message) {
+	select {
+	case p.callSlots <- struct{}{}:
+		go func() {
+			p.handleCall(call)
+			<-p.callSlots
+		}()
+	default:
+		if call.Flags&FlagNoReplyExpected != 0 {
+			return
+		}
+		reply := newErrorReply(call, errNameLimitsExceeded, "too many method calls in progress")
+		if err := p.send(reply); err != nil {
+			logPrintf("reply to %s.%s: %s", call.Iface, call.Member, err)
+		}
+	}
+}
+
+// handleCall answers an incoming method call.
+func (p *Connection) handleCall(call *Message) {
+	var reply *Message
+	m, ok := p.exportedMethod(call)
+	if !ok {
+		reply = newErrorReply(call, errNameUnknownMethod,
+			fmt.Sprintf("no method %q in interface %q at object path %q",
+				call.Member, call.Iface, call.Path))
+	} else if call.Sig != m.InSig {
+		reply = newErrorReply(call, errNameInvalidArgs,
+			fmt.Sprintf("call signature is %q, method %s expects %q", call.Sig, call.Member, m.InSig))
+	} else if err := call.parseParams(); err != nil {
+		reply = newErrorReply(call, errNameFailed, err.Error())
+	} else if out, err := callHandler(m, call); err != nil {
+		reply = newHandlerErrorReply(call, err)
+	} else {
+		reply = newMethodReturn(call)
+		reply.Sig = m.OutSig
+		reply.Params = out
+	}
+	if call.Flags&FlagNoReplyExpected != 0 {
+		return
+	}
+	buf, err := p.marshal(reply)
+	if err != nil && reply.Type == TypeMethodReturn {
+		// The handler returned values not matching OutSig.
+		logPrintf("reply to %s.%s: %s", call.Iface, call.Member, err)
+		reply = newErrorReply(call, errNameFailed, "invalid reply: "+err.Error())
+		buf, err = p.marshal(reply)
+	}
+	if err == nil {
+		_, err = p.conn.Write(buf)
+	}
+	if err != nil {
+		logPrintf("reply to %s.%s: %s", call.Iface, call.Member, err)
+	}
+}
+
+// callHandler runs the handler of method m, reporting a panic
+// as an error.
+func callHandler(m ExportedMethod, call *Message) (out []interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logPrintf("handler of %s.%s panicked: %v", call.Iface, call.Member, r)
+			err = fmt.Errorf("method handler failed: %v", r)
+		}
+	}()
+	return m.Handler(call)
+}
+
+func newMethodReturn(call *Message) *Message {
+	reply := NewMessage()
+	reply.Type = TypeMethodReturn
+	reply.replySerial = call.serial
+	reply.Dest = call.Sender
+	return reply
+}
+
+func newErrorReply(call *Message, name, text string) *Message {
+	reply := NewMessage()
+	reply.Type = TypeError
+	reply.replySerial = call.serial
+	reply.Dest = call.Sender
+	reply.ErrorName = name
+	reply.Sig = "s"
+	reply.Params = []interface{}{text}
+	return reply
+}
+
+// newHandlerErrorReply builds the reply to call for the error
+// returned by its handler. A *DBusError keeps its name, other errors
+// are reported as failures.
+func newHandlerErrorReply(call *Message, err error) *Message {
+	var e *DBusError
+	if errors.As(err, &e) && e.Name != "" {
+		return newErrorReply(call, e.Name, e.Message)
+	}
+	return newErrorReply(call, errNameFailed, err.Error())
+}
+
+// send writes msg to the connection without waiting for a reply.
+func (p *Connection) send(msg *Message) (err error) {
+	defer catchPanicErr(&err)
+	buf, err := p.marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = p.conn.Write(buf)
+	return err
+}
@@ -0,0 +1,331 @@
+package dbus
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// callTestConnection sends call to conn from the bus side and returns
+// the reply.
+func callTestConnection(t *testing.T, call *Message) *Message {
+	replies := make(chan *Message, 1)
+	conn, bus := newTestBus(func(msg *Message) *Message {
+		if msg.Type == TypeMethodReturn || msg.Type == TypeError {
+			replies <- msg
+		}
+		return nil
+	})
+	defer conn.Close()
+	sendTestMessage(t, bus, call)
+	select {
+	case reply := <-replies:
+		return reply
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply received")
+	}
+	return nil
+}
+
+func newTestCall(path, iface, member string) *Message {
+	msg := NewMessage()
+	msg.Type = TypeMethodCall
+	msg.Sender = ":1.42"
+	msg.Path = path
+	msg.Iface = iface
+	msg.Member = member
+	return msg
+}
+
+func TestPing(t *testing.T) {
+	call := newTestCall("/", "org.freedesktop.DBus.Peer", "Ping")
+	reply := callTestConnection(t, call)
+	if reply.Type != TypeMethodReturn {
+		t.Fatalf("got %s %s, want a method return", reply.Type, reply.ErrorName)
+	}
+	if reply.replySerial != call.serial {
+		t.Errorf("reply serial is %d, want %d", reply.replySerial, call.serial)
+	}
+}
+
+func TestIntrospectDefault(t *testing.T) {
+	reply := callTestConnection(t, newTestCall("/org/example", "org.freedesktop.DBus.Introspectable", "Introspect"))
+	if reply.Type != TypeMethodReturn || reply.Sig != "s" {
+		t.Fatalf("got %s with signature %q", reply.Type, reply.Sig)
+	}
+	intro, err := NewIntrospect(reply.Params[0].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if intro.GetInterfaceData("org.freedesktop.DBus.Peer") == nil {
+		t.Error("Peer interface missing from introspection data")
+	}
+}
+
+func TestUnknownMethod(t *testing.T) {
+	reply := callTestConnection(t, newTestCall("/", "org.example", "Frobate"))
+	if reply.Type != TypeError || reply.ErrorName != errNameUnknownMethod {
+		t.Errorf("got %s %s, want an UnknownMethod error", reply.Type, reply.ErrorName)
+	}
+}
+
+func TestExport(t *testing.T) {
+	replies := make(chan *Message, 1)
+	conn, bus := newTestBus(func(msg *Message) *Message {
+		if msg.Type == TypeMethodReturn {
+			replies <- msg
+		}
+		return nil
+	})
+	defer conn.Close()
+	conn.Export("/", "org.freedesktop.DBus.Peer", map[string]ExportedMethod{
+		"GetMachineId": {OutSig: "s", Handler: func(*Message) ([]interface{}, error) {
+			return []interface{}{"0123456789abcdef"}, nil
+		}},
+	})
+	sendTestMessage(t, bus, newTestCall("/", "org.freedesktop.DBus.Peer", "GetMachineId"))
+	select {
+	case reply := <-replies:
+		if reply.Dest != ":1.42" || len(reply.Params) != 1 || reply.Params[0] != "0123456789abcdef" {
+			t.Errorf("got reply to %s: %v", reply.Dest, reply.Params)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply received")
+	}
+}
+
+func TestExportError(t *testing.T) {
+	errDenied := &DBusError{Name: "org.freedesktop.DBus.Error.AccessDenied", Message: "not allowed"}
+	tests := []struct {
+		err  error
+		want DBusError
+	}{
+		{errDenied, DBusError{Name: errDenied.Name, Message: "not allowed"}},
+		{fmt.Errorf("frobate: %w", errDenied), DBusError{Name: errDenied.Name, Message: "not allowed"}},
+		{errors.New("out of frobs"), DBusError{Name: errNameFailed, Message: "out of frobs"}},
+	}
+	for _, test := range tests {
+		replies := make(chan *Message, 1)
+		conn, bus := newTestBus(func(msg *Message) *Message {
+			if msg.Type == TypeError || msg.Type == TypeMethodReturn {
+				replies <- msg
+			}
+			return nil
+		})
+		err := test.err
+		conn.Export("/org/example", "org.example", map[string]ExportedMethod{
+			"Frobate": {Handler: func(*Message) ([]interface{}, error) { return nil, err }},
+		})
+		sendTestMessage(t, bus, newTestCall("/org/example", "org.example", "Frobate"))
+		select {
+		case reply := <-replies:
+			if reply.Type != TypeError {
+				t.Fatalf("got %s, want an error", reply.Type)
+			}
+			e := newDBusError(reply)
+			if e.Name != test.want.Name || e.Message != test.want.Message {
+				t.Errorf("%v: got error %v, want %v", test.err, e, &test.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reply received")
+		}
+		conn.Close()
+	}
+}
+
+func TestExportInvalidCalls(t *testing.T) {
+	SetLogger(nil)
+	defer SetLogger(log.Default())
+	tests := []struct {
+		method ExportedMethod
+		sig    string
+		params []interface{}
+		want   string
+	}{
+		// Values not matching OutSig.
+		{ExportedMethod{OutSig: "u", Handler: func(*Message) ([]interface{}, error) {
+			return []interface{}{"one"}, nil
+		}}, "", nil, errNameFailed},
+		// A call not matching InSig.
+		{ExportedMethod{InSig: "s", Handler: func(*Message) ([]interface{}, error) {
+			return nil, nil
+		}}, "u", []interface{}{uint32(1)}, errNameInvalidArgs},
+		// A panicking handler.
+		{ExportedMethod{Handler: func(*Message) ([]interface{}, error) {
+			panic("out of frobs")
+		}}, "", nil, errNameFailed},
+	}
+	for i, test := range tests {
+		replies := make(chan *Message, 1)
+		conn, bus := newTestBus(func(msg *Message) *Message {
+			if msg.Type == TypeError || msg.Type == TypeMethodReturn {
+				replies <- msg
+			}
+			return nil
+		})
+		conn.Export("/org/example", "org.example", map[string]ExportedMethod{"Frobate": test.method})
+		call := newTestCall("/org/example", "org.example", "Frobate")
+		call.Sig = test.sig
+		call.Params = test.params
+		sendTestMessage(t, bus, call)
+		select {
+		case reply := <-replies:
+			if reply.Type != TypeError || reply.ErrorName != test.want {
+				t.Errorf("#%d: got %s %s, want error %s", i, reply.Type, reply.ErrorName, test.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("#%d: no reply received", i)
+		}
+		conn.Close()
+	}
+}
+
+func TestCallHandlerLimit(t *testing.T) {
+	replies := make(chan *Message, maxCallHandlers+1)
+	conn, bus := newTestBus(func(msg *Message) *Message {
+		if msg.Type == TypeMethodReturn || msg.Type == TypeError {
+			replies <- msg
+		}
+		return nil
+	})
+	defer conn.Close()
+	release := make(chan struct{})
+	conn.Export("/org/example", "org.example", map[string]ExportedMethod{
+		"Wait": {Handler: func(*Message) ([]interface{}, error) {
+			<-release
+			return nil, nil
+		}},
+	})
+	for i := 0; i <= maxCallHandlers; i++ {
+		sendTestMessage(t, bus, newTestCall("/org/example", "org.example", "Wait"))
+	}
+	// The call past the limit is rejected at once.
+	select {
+	case reply := <-replies:
+		if reply.Type != TypeError || reply.ErrorName != errNameLimitsExceeded {
+			t.Errorf("got %s %s, want a LimitsExceeded error", reply.Type, reply.ErrorName)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply received")
+	}
+	close(release)
+	for i := 0; i < maxCallHandlers; i++ {
+		select {
+		case reply := <-replies:
+			if reply.Type != TypeMethodReturn {
+				t.Errorf("got %s %s, want a method return", reply.Type, reply.ErrorName)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reply received")
+		}
+	}
+}
+
+func TestEmitPropertiesChanged(t *testing.T) {
+	signals := make(chan *Message, 1)
+	conn := newTestConnection(func(msg *Message) *Message {
+		if msg.Type == TypeSignal {
+			signals <- msg
+		}
+		return nil
+	})
+	changed := map[string]interface{}{
+		"Name":  "example",
+		"Count": uint32(3),
+		"Tags":  []string{"a", "b"},
+	}
+	invalidated := []string{"Cache"}
+	if err := conn.EmitPropertiesChanged("/org/example", "org.example", changed, invalidated); err != nil {
+		t.Fatal(err)
+	}
+	var msg *Message
+	select {
+	case msg = <-signals:
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal not received")
+	}
+	if msg.Path != "/org/example" || msg.Iface != "org.freedesktop.DBus.Properties" ||
+		msg.Member != "PropertiesChanged" || msg.Sig != "sa{sv}as" || msg.Dest != "" {
+		t.Fatalf("bad signal header: %+v", msg)
+	}
+	var body struct {
+		Iface       string
+		Changed     map[string]interface{}
+		Invalidated []string
+	}
+	if err := msg.unmarshalValue(reflect.ValueOf(&body).Elem()); err != nil {
+		t.Fatal(err)
+	}
+	if body.Iface != "org.example" {
+		t.Errorf("got interface %q", body.Iface)
+	}
+	want := map[string]interface{}{
+		"Name":  "example",
+		"Count": uint32(3),
+		"Tags":  []interface{}{"a", "b"},
+	}
+	if !reflect.DeepEqual(body.Changed, want) {
+		t.Errorf("got changed properties %#v, want %#v", body.Changed, want)
+	}
+	if !reflect.DeepEqual(body.Invalidated, invalidated) {
+		t.Errorf("got invalidated properties %q, want %q", body.Invalidated, invalidated)
+	}
+}
+
+func TestIntrospectExported(t *testing.T) {
+	replies := make(chan *Message, 1)
+	conn, bus := newTestBus(func(msg *Message) *Message {
+		if msg.Type == TypeMethodReturn {
+			replies <- msg
+		}
+		return nil
+	})
+	defer conn.Close()
+	methods := map[string]ExportedMethod{
+		"Frobate": {InSig: "sa{sv}", OutSig: "u", Handler: func(*Message) ([]interface{}, error) {
+			return []interface{}{uint32(1)}, nil
+		}},
+	}
+	conn.Export("/org/example/a", "org.example.Frobnicator", methods)
+	conn.Export("/org/example/b/c", "org.example.Frobnicator", methods)
+
+	introspect := func(path string) string {
+		sendTestMessage(t, bus, newTestCall(path, "org.freedesktop.DBus.Introspectable", "Introspect"))
+		select {
+		case reply := <-replies:
+			return reply.Params[0].(string)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reply received")
+		}
+		return ""
+	}
+
+	var node struct {
+		Nodes []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"node"`
+	}
+	if err := xml.Unmarshal([]byte(introspect("/org/example")), &node); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.Nodes) != 2 || node.Nodes[0].Name != "a" || node.Nodes[1].Name != "b" {
+		t.Errorf("got child nodes %+v, want a and b", node.Nodes)
+	}
+
+	intro, err := NewIntrospect(introspect("/org/example/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface := intro.GetInterfaceData("org.example.Frobnicator")
+	if iface == nil {
+		t.Fatal("exported interface missing from introspection data")
+	}
+	m := iface.GetMethodData("Frobate")
+	if m == nil || m.GetInSignature() != "sa{sv}" || m.GetOutSignature() != "u" {
+		t.Errorf("got method %+v", m)
+	}
+}
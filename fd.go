@@ -0,0 +1,55 @@
+package dbus
+
+import (
+	"net"
+	"syscall"
+)
+
+// maxFds is the maximal number of file descriptors read
+// in a single control message.
+const maxFds = 16
+
+// fdReader reads from a unix socket, collecting the file
+// descriptors passed as SCM_RIGHTS control messages.
+type fdReader struct {
+	conn *net.UnixConn
+	fds  []int
+}
+
+func (r *fdReader) Read(b []byte) (int, error) {
+	oob := make([]byte, syscall.CmsgSpace(maxFds*4))
+	n, oobn, _, _, err := r.conn.ReadMsgUnix(b, oob)
+	if oobn > 0 {
+		cmsgs, perr := syscall.ParseSocketControlMessage(oob[:oobn])
+		if perr != nil {
+			return n, perr
+		}
+		for i := range cmsgs {
+			fds, perr := syscall.ParseUnixRights(&cmsgs[i])
+			if perr != nil {
+				continue
+			}
+			r.fds = append(r.fds, fds...)
+		}
+	}
+	return n, err
+}
+
+// take removes the first n received file descriptors
+// from the queue and returns them.
+func (r *fdReader) take(n uint32) []int {
+	if int(n) > len(r.fds) {
+		n = uint32(len(r.fds))
+	}
+	fds := r.fds[:n:n]
+	r.fds = r.fds[n:]
+	return fds
+}
+
+// discard closes the first n received file descriptors, which belong
+// to a message that could not be decoded.
+func (r *fdReader) discard(n uint32) {
+	for _, fd := range r.take(n) {
+		syscall.Close(fd)
+	}
+}
@@ -0,0 +1,429 @@
+package dbus
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Textual representation of values, in the style of GVariant.
+
+// FormatValue renders a decoded value of the given signature in a
+// GVariant-like textual form: strings are quoted ('a'), arrays are
+// written as ['a', 'b'], structs as (1, 'x'), dictionaries as
+// {'k': <1>} and variants as <value>.
+func FormatValue(sig string, val interface{}) string {
+	s, rest, err := parseOneSignature(sig)
+	if err != nil || rest != "" {
+		return fmt.Sprint(val)
+	}
+	var buf bytes.Buffer
+	formatValue(&buf, s, val)
+	return buf.String()
+}
+
+var quoteReplacer = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+func formatString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('\'')
+	quoteReplacer.WriteString(buf, s)
+	buf.WriteByte('\'')
+}
+
+func formatValue(buf *bytes.Buffer, sig signature, val interface{}) {
+	switch sig := sig.(type) {
+	case arraySig:
+		vals, _ := val.([]interface{})
+		buf.WriteByte('[')
+		for i, v := range vals {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			formatValue(buf, sig.Elem, v)
+		}
+		buf.WriteByte(']')
+	case dictSig:
+		buf.WriteByte('{')
+		for i, kv := range dictEntries(val) {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			formatValue(buf, sig.Key, kv[0])
+			buf.WriteString(": ")
+			formatValue(buf, sig.Value, kv[1])
+		}
+		buf.WriteByte('}')
+	case structSig:
+		vals, _ := val.([]interface{})
+		buf.WriteByte('(')
+		for i, fldsig := range sig {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			if i < len(vals) {
+				formatValue(buf, fldsig, vals[i])
+			}
+		}
+		if len(sig) == 1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte(')')
+	case basicSig:
+		switch sig {
+		case 's', 'o', 'g':
+			formatString(buf, fmt.Sprint(val))
+		case 'v':
+			buf.WriteByte('<')
+			formatVariant(buf, val)
+			buf.WriteByte('>')
+		default:
+			fmt.Fprint(buf, val)
+		}
+	}
+}
+
+// formatVariant renders the contents of a variant, whose
+// signature is unknown after decoding.
+func formatVariant(buf *bytes.Buffer, val interface{}) {
+	switch v := val.(type) {
+	case string:
+		formatString(buf, v)
+	case ObjectPath:
+		formatString(buf, string(v))
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, elem := range v {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			formatVariant(buf, elem)
+		}
+		buf.WriteByte(']')
+	default:
+		if reflect.ValueOf(val).Kind() == reflect.Map {
+			buf.WriteByte('{')
+			for i, kv := range dictEntries(val) {
+				if i > 0 {
+					buf.WriteString(", ")
+				}
+				formatVariant(buf, kv[0])
+				buf.WriteString(": ")
+				formatVariant(buf, kv[1])
+			}
+			buf.WriteByte('}')
+			return
+		}
+		fmt.Fprint(buf, val)
+	}
+}
+
+// dictEntries returns the key-value pairs of a dictionary, represented
+// either as decoded ([]interface{} of key-value pairs) or as a Go map.
+// Map entries are sorted by key.
+func dictEntries(val interface{}) [][2]interface{} {
+	var entries [][2]interface{}
+	if vals, ok := val.([]interface{}); ok {
+		for _, v := range vals {
+			if kv, ok := v.([]interface{}); ok && len(kv) == 2 {
+				entries = append(entries, [2]interface{}{kv[0], kv[1]})
+			}
+		}
+		return entries
+	}
+	m := reflect.ValueOf(val)
+	if m.Kind() != reflect.Map {
+		return nil
+	}
+	for _, k := range m.MapKeys() {
+		entries = append(entries, [2]interface{}{k.Interface(), m.MapIndex(k).Interface()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return fmt.Sprint(entries[i][0]) < fmt.Sprint(entries[j][0])
+	})
+	return entries
+}
+
+// ParseValue parses the textual representation of a value of the
+// given signature, as produced by FormatValue, into the representation
+// used for method arguments: arrays and structs are []interface{},
+// dictionaries are []interface{} of key-value pairs. The contents of
+// variants are typed after their syntax: strings, booleans, int32,
+// float64 or containers of those.
+func ParseValue(sig string, text string) (interface{}, error) {
+	s, rest, err := parseOneSignature(sig)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("trailing signature %q", rest)
+	}
+	p := &valueParser{s: text}
+	val, err := p.parse(s)
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		return nil, p.errorf("trailing characters")
+	}
+	return val, nil
+}
+
+type valueParser struct {
+	s   string
+	pos int
+}
+
+func (p *valueParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("parse error at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *valueParser) skipSpace() {
+	for p.pos < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space character, or 0 at end of input.
+func (p *valueParser) peek() byte {
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+func (p *valueParser) expect(c byte) error {
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+// token reads a bare word such as a number or a boolean.
+func (p *valueParser) token() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) && strings.IndexByte(" \t\r\n,:()[]{}<>'", p.s[p.pos]) < 0 {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *valueParser) quoted() (string, error) {
+	if err := p.expect('\''); err != nil {
+		return "", err
+	}
+	var buf []byte
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		switch c {
+		case '\'':
+			return string(buf), nil
+		case '\\':
+			if p.pos == len(p.s) {
+				return "", p.errorf("unterminated escape")
+			}
+			c = p.s[p.pos]
+			p.pos++
+		}
+		buf = append(buf, c)
+	}
+	return "", p.errorf("unterminated string")
+}
+
+// list parses a comma-separated list of items up to the closing
+// character end.
+func (p *valueParser) list(end byte, item func() error) error {
+	if p.peek() == end {
+		p.pos++
+		return nil
+	}
+	for {
+		if err := item(); err != nil {
+			return err
+		}
+		switch p.peek() {
+		case ',':
+			p.pos++
+			if p.peek() == end {
+				p.pos++
+				return nil
+			}
+		case end:
+			p.pos++
+			return nil
+		default:
+			return p.errorf("expected ',' or %q", end)
+		}
+	}
+}
+
+func (p *valueParser) parse(sig signature) (interface{}, error) {
+	switch sig := sig.(type) {
+	case arraySig:
+		if err := p.expect('['); err != nil {
+			return nil, err
+		}
+		vals := make([]interface{}, 0)
+		err := p.list(']', func() error {
+			v, err := p.parse(sig.Elem)
+			vals = append(vals, v)
+			return err
+		})
+		return vals, err
+	case dictSig:
+		if err := p.expect('{'); err != nil {
+			return nil, err
+		}
+		vals := make([]interface{}, 0)
+		err := p.list('}', func() error {
+			k, err := p.parse(sig.Key)
+			if err != nil {
+				return err
+			}
+			if err = p.expect(':'); err != nil {
+				return err
+			}
+			v, err := p.parse(sig.Value)
+			vals = append(vals, []interface{}{k, v})
+			return err
+		})
+		return vals, err
+	case structSig:
+		if err := p.expect('('); err != nil {
+			return nil, err
+		}
+		vals := make([]interface{}, 0, len(sig))
+		err := p.list(')', func() error {
+			if len(vals) == len(sig) {
+				return p.errorf("too many struct fields")
+			}
+			v, err := p.parse(sig[len(vals)])
+			vals = append(vals, v)
+			return err
+		})
+		if err == nil && len(vals) != len(sig) {
+			err = p.errorf("expected %d struct fields, got %d", len(sig), len(vals))
+		}
+		return vals, err
+	}
+
+	switch sig := sig.(basicSig); sig {
+	case 's', 'o', 'g':
+		return p.quoted()
+	case 'v':
+		if err := p.expect('<'); err != nil {
+			return nil, err
+		}
+		v, err := p.parseVariant()
+		if err != nil {
+			return nil, err
+		}
+		return v, p.expect('>')
+	case 'b':
+		switch tok := p.token(); tok {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		default:
+			return nil, p.errorf("invalid boolean %q", tok)
+		}
+	default:
+		return p.number(sig, p.token())
+	}
+}
+
+func (p *valueParser) number(sig basicSig, tok string) (interface{}, error) {
+	var v interface{}
+	var err error
+	switch sig {
+	case 'y':
+		var x uint64
+		x, err = strconv.ParseUint(tok, 0, 8)
+		v = byte(x)
+	case 'n':
+		var x int64
+		x, err = strconv.ParseInt(tok, 0, 16)
+		v = int16(x)
+	case 'q':
+		var x uint64
+		x, err = strconv.ParseUint(tok, 0, 16)
+		v = uint16(x)
+	case 'i':
+		var x int64
+		x, err = strconv.ParseInt(tok, 0, 32)
+		v = int32(x)
+	case 'u', 'h':
+		var x uint64
+		x, err = strconv.ParseUint(tok, 0, 32)
+		v = uint32(x)
+	case 'x':
+		v, err = strconv.ParseInt(tok, 0, 64)
+	case 't':
+		v, err = strconv.ParseUint(tok, 0, 64)
+	case 'd':
+		v, err = strconv.ParseFloat(tok, 64)
+	default:
+		return nil, p.errorf("unsupported type %q", byte(sig))
+	}
+	if err != nil {
+		return nil, p.errorf("invalid value %q for type %q", tok, byte(sig))
+	}
+	return v, nil
+}
+
+// parseVariant parses the contents of a variant, guessing their type.
+func (p *valueParser) parseVariant() (interface{}, error) {
+	switch p.peek() {
+	case '\'':
+		return p.quoted()
+	case '<':
+		return p.parse(basicSig('v'))
+	case '[', '(':
+		end := byte(']')
+		if p.s[p.pos] == '(' {
+			end = ')'
+		}
+		p.pos++
+		vals := make([]interface{}, 0)
+		err := p.list(end, func() error {
+			v, err := p.parseVariant()
+			vals = append(vals, v)
+			return err
+		})
+		return vals, err
+	case '{':
+		p.pos++
+		vals := make([]interface{}, 0)
+		err := p.list('}', func() error {
+			k, err := p.parseVariant()
+			if err != nil {
+				return err
+			}
+			if err = p.expect(':'); err != nil {
+				return err
+			}
+			v, err := p.parseVariant()
+			vals = append(vals, []interface{}{k, v})
+			return err
+		})
+		return vals, err
+	}
+	switch tok := p.token(); {
+	case tok == "true":
+		return true, nil
+	case tok == "false":
+		return false, nil
+	case strings.ContainsAny(tok, ".eE") && !strings.HasPrefix(tok, "0x"):
+		return p.number('d', tok)
+	default:
+		return p.number('i', tok)
+	}
+}
@@ -0,0 +1,111 @@
+package dbus
+
+import (
+	"reflect"
+	"testing"
+)
+
+type formatTest struct {
+	sig  string
+	val  interface{}
+	text string
+	// parsed is the result of ParseValue, when it differs from val.
+	parsed interface{}
+}
+
+var formatTests = []formatTest{
+	{"s", "it's", `'it\'s'`, nil},
+	{"u", uint32(42), "42", nil},
+	{"as", []interface{}{"a", "b"}, "['a', 'b']", nil},
+	{"as", []interface{}{}, "[]", nil},
+	{"a{sv}", []interface{}{
+		[]interface{}{"Name", "x"},
+		[]interface{}{"Size", uint32(1)},
+		[]interface{}{"Tags", []interface{}{"t1", "t2"}},
+	}, "{'Name': <'x'>, 'Size': <1>, 'Tags': <['t1', 't2']>}", []interface{}{
+		[]interface{}{"Name", "x"},
+		[]interface{}{"Size", int32(1)},
+		[]interface{}{"Tags", []interface{}{"t1", "t2"}},
+	}},
+	{"a{su}", map[string]uint32{"b": 2, "a": 1}, "{'a': 1, 'b': 2}", []interface{}{
+		[]interface{}{"a", uint32(1)},
+		[]interface{}{"b", uint32(2)},
+	}},
+	{"(us)", []interface{}{uint32(1), "x"}, "(1, 'x')", nil},
+	{"(s)", []interface{}{"x"}, "('x',)", nil},
+	{"a(ob)", []interface{}{[]interface{}{"/a", true}}, "[('/a', true)]", nil},
+}
+
+func TestFormatValue(t *testing.T) {
+	for _, test := range formatTests {
+		if s := FormatValue(test.sig, test.val); s != test.text {
+			t.Errorf("FormatValue(%q, %v): got %s, want %s", test.sig, test.val, s, test.text)
+		}
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	for _, test := range formatTests {
+		val, err := ParseValue(test.sig, test.text)
+		if err != nil {
+			t.Errorf("ParseValue(%q, %q): %s", test.sig, test.text, err)
+			continue
+		}
+		want := test.val
+		if test.parsed != nil {
+			want = test.parsed
+		}
+		if !reflect.DeepEqual(val, want) {
+			t.Errorf("ParseValue(%q, %q): got %#v, want %#v", test.sig, test.text, val, want)
+		}
+		// Round trip through FormatValue.
+		if s := FormatValue(test.sig, val); s != test.text {
+			t.Errorf("round trip of %s gives %s", test.text, s)
+		}
+	}
+}
+
+func TestParseValueTypes(t *testing.T) {
+	val, err := ParseValue("(ynqixtd)", "(1, -2, 3, -4, 5, 6, 1.5)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{byte(1), int16(-2), uint16(3), int32(-4), int64(5), uint64(6), 1.5}
+	if !reflect.DeepEqual(val, want) {
+		t.Errorf("got %#v, want %#v", val, want)
+	}
+
+	val, err = ParseValue("a{sv}", "{'a': <'x'>, 'b': <1>, 'c': <true>, 'd': <[1, 2]>}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []interface{}{
+		[]interface{}{"a", "x"},
+		[]interface{}{"b", int32(1)},
+		[]interface{}{"c", true},
+		[]interface{}{"d", []interface{}{int32(1), int32(2)}},
+	}
+	if !reflect.DeepEqual(val, want) {
+		t.Errorf("got %#v, want %#v", val, want)
+	}
+}
+
+func TestParseValueErrors(t *testing.T) {
+	tests := []struct{ sig, text string }{
+		{"s", "abc"},
+		{"s", "'abc"},
+		{"u", "-1"},
+		{"y", "256"},
+		{"as", "['a' 'b']"},
+		{"(us)", "(1)"},
+		{"(us)", "(1, 'a', 2)"},
+		{"a{su}", "{'a' 1}"},
+		{"b", "yes"},
+		{"u", "1 2"},
+	}
+	for _, test := range tests {
+		if v, err := ParseValue(test.sig, test.text); err == nil {
+			t.Errorf("ParseValue(%q, %q): got %#v, expected an error", test.sig, test.text, v)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package dbus
 import (
 	"bytes"
 	"encoding/xml"
+	"fmt"
 	"strings"
 )
 
@@ -28,10 +29,17 @@ type signalData struct {
 	Arg  []argData `xml:"arg"`
 }
 
+type propertyData struct {
+	Name   string `xml:"name,attr"`
+	Type   string `xml:"type,attr"`
+	Access string `xml:"access,attr"`
+}
+
 type interfaceData struct {
-	Name   string       `xml:"name,attr"`
-	Method []methodData `xml:"method"`
-	Signal []signalData `xml:"signal"`
+	Name     string         `xml:"name,attr"`
+	Method   []methodData   `xml:"method"`
+	Signal   []signalData   `xml:"signal"`
+	Property []propertyData `xml:"property"`
 }
 
 type introspect struct {
@@ -47,6 +55,7 @@ type Introspect interface {
 type InterfaceData interface {
 	GetMethodData(name string) MethodData
 	GetSignalData(name string) SignalData
+	GetPropertyData(name string) PropertyData
 	GetName() string
 }
 
@@ -61,6 +70,13 @@ type SignalData interface {
 	GetSignature() string
 }
 
+type PropertyData interface {
+	GetName() string
+	GetType() string
+	// GetAccess returns "read", "write" or "readwrite".
+	GetAccess() string
+}
+
 func NewIntrospect(xmlIntro string) (Introspect, error) {
 	intro := new(introspect)
 	buff := bytes.NewBufferString(xmlIntro)
@@ -68,10 +84,52 @@ func NewIntrospect(xmlIntro string) (Introspect, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err = intro.check(); err != nil {
+		return nil, err
+	}
 
 	return intro, nil
 }
 
+// check verifies that argument types are valid single complete types.
+func (p introspect) check() error {
+	for _, iface := range p.Interface {
+		for _, m := range iface.Method {
+			if err := checkArgs(m.Arg); err != nil {
+				return fmt.Errorf("%s.%s: %s", iface.Name, m.Name, err)
+			}
+		}
+		for _, sig := range iface.Signal {
+			if err := checkArgs(sig.Arg); err != nil {
+				return fmt.Errorf("%s.%s: %s", iface.Name, sig.Name, err)
+			}
+		}
+		for _, prop := range iface.Property {
+			if _, rest, err := parseOneSignature(prop.Type); err != nil || rest != "" {
+				return fmt.Errorf("%s: property %s has invalid type %q", iface.Name, prop.Name, prop.Type)
+			}
+		}
+	}
+	return nil
+}
+
+func checkArgs(args []argData) error {
+	for i, arg := range args {
+		_, rest, err := parseOneSignature(arg.Type)
+		if err == nil && rest != "" {
+			err = fmt.Errorf("trailing characters %q", rest)
+		}
+		if err != nil {
+			name := arg.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i)
+			}
+			return fmt.Errorf("argument %s has invalid type %q: %s", name, arg.Type, err)
+		}
+	}
+	return nil
+}
+
 func (p introspect) GetInterfaceData(name string) InterfaceData {
 	for _, v := range p.Interface {
 		if v.Name == name {
@@ -99,6 +157,15 @@ func (p interfaceData) GetSignalData(name string) SignalData {
 	return nil
 }
 
+func (p interfaceData) GetPropertyData(name string) PropertyData {
+	for _, v := range p.Property {
+		if v.GetName() == name {
+			return v
+		}
+	}
+	return nil
+}
+
 func (p interfaceData) GetName() string { return p.Name }
 
 func (p methodData) GetInSignature() (sig string) {
@@ -129,3 +196,7 @@ func (p signalData) GetSignature() (sig string) {
 }
 
 func (p signalData) GetName() string { return p.Name }
+
+func (p propertyData) GetName() string   { return p.Name }
+func (p propertyData) GetType() string   { return p.Type }
+func (p propertyData) GetAccess() string { return p.Access }
@@ -1,6 +1,7 @@
 package dbus
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -75,4 +76,36 @@ func TestIntrospect(t *testing.T) {
 		t.Error("Failed #4-3")
 	}
 
+	prop := intf.GetPropertyData("Bar")
+	if prop == nil {
+		t.Fatal("Failed #5-1")
+	}
+	if prop.GetType() != "y" || prop.GetAccess() != "readwrite" {
+		t.Error("Failed #5-2")
+	}
+	if intf.GetPropertyData("Hoo") != nil {
+		t.Error("Failed #5-3")
+	}
+
+}
+
+func TestIntrospectInvalidArgType(t *testing.T) {
+	_, err := NewIntrospect(`<node><interface name="org.example.Service">
+		<method name="Frobate">
+		  <arg name="foo" type="i" direction="in"/>
+		  <arg name="bar" type="zzz" direction="out"/>
+		</method></interface></node>`)
+	if err == nil {
+		t.Fatal("expected an error for an invalid argument type")
+	}
+	const want = `org.example.Service.Frobate: argument bar has invalid type "zzz"`
+	if !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("got error %q, want prefix %q", err, want)
+	}
+	// Two complete types in a single argument.
+	if _, err := NewIntrospect(`<node><interface name="org.example.Service">
+		<signal name="Changed"><arg type="ss"/></signal>
+		</interface></node>`); err == nil {
+		t.Error("expected an error for a multiple type argument")
+	}
 }
@@ -0,0 +1,50 @@
+package dbus
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Internal errors that cannot be returned to a caller, such as
+// undecodable messages, are logged.
+var (
+	logLock sync.Mutex
+	logger  = log.Default()
+	verbose = true
+)
+
+// SetLogger sets the logger receiving the internal errors of the
+// package. By default, they go to the standard logger. A nil logger
+// discards them.
+func SetLogger(l *log.Logger) {
+	logLock.Lock()
+	logger = l
+	logLock.Unlock()
+}
+
+// SetVerbose enables or disables the logging of internal errors.
+// It is enabled by default.
+func SetVerbose(v bool) {
+	logLock.Lock()
+	verbose = v
+	logLock.Unlock()
+}
+
+func logPrint(v ...interface{}) {
+	logOutput(fmt.Sprint(v...))
+}
+
+func logPrintf(format string, v ...interface{}) {
+	logOutput(fmt.Sprintf(format, v...))
+}
+
+func logOutput(s string) {
+	logLock.Lock()
+	l, v := logger, verbose
+	logLock.Unlock()
+	if l != nil && v {
+		// Report the file and line of the logPrint caller.
+		l.Output(3, s)
+	}
+}
@@ -0,0 +1,94 @@
+package dbus
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sendMalformedMessage sends a message with an invalid header field.
+func sendMalformedMessage(t *testing.T, conn io.Writer) {
+	const msg = "l\x04\x01\x01\x00\x00\x00\x00\x01\x00\x00\x00\x08\x00\x00\x00" +
+		"\x00\x01g\x00\x00\x00\x00\x00"
+	if _, err := io.WriteString(conn, msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitLog waits for s to be logged to buf.
+func waitLog(t *testing.T, buf *syncBuffer, s string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(buf.String(), s) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%q not logged, got %q", s, buf.String())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	buf := new(syncBuffer)
+	SetLogger(log.New(buf, "", 0))
+	defer SetLogger(log.Default())
+
+	conn, bus := newTestBus(func(msg *Message) *Message { return nil })
+	defer conn.Close()
+	sendMalformedMessage(t, bus)
+	waitLog(t, buf, "invalid header field")
+
+	// Nothing is logged when verbosity is disabled.
+	SetVerbose(false)
+	defer SetVerbose(true)
+	logPrint("silenced")
+	if strings.Contains(buf.String(), "silenced") {
+		t.Errorf("message logged with verbosity disabled")
+	}
+}
+
+func TestUnknownSerialNotLogged(t *testing.T) {
+	buf := new(syncBuffer)
+	SetLogger(log.New(buf, "", 0))
+	defer SetLogger(log.Default())
+
+	conn, bus := newTestBus(func(msg *Message) *Message { return nil })
+	defer conn.Close()
+	// A late reply to a call which timed out.
+	conn.expectReply(12345, 1)
+	conn.replyLock.Lock()
+	delete(conn.replyChans, 12345)
+	conn.replyLock.Unlock()
+	reply := NewMessage()
+	reply.Type = TypeMethodReturn
+	reply.replySerial = 12345
+	sendTestMessage(t, bus, reply)
+
+	// Messages are handled in order: once the next one is logged,
+	// the reply has been dropped.
+	sendMalformedMessage(t, bus)
+	waitLog(t, buf, "invalid header field")
+	if strings.Contains(buf.String(), "12345") {
+		t.Errorf("late reply logged: %q", buf.String())
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
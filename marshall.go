@@ -1,11 +1,17 @@
 package dbus
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
+	"os"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 // Signature parsing.
@@ -60,6 +66,8 @@ func parseSignature(s string) (ss []signature, err error) {
 
 var (
 	errMissingCloseParen = errors.New("missing ')' at end of struct signature")
+	errMissingCloseBrace = errors.New("missing '}' at end of dict entry signature")
+	errEmptyStruct       = errors.New("empty structures are not allowed")
 )
 
 func parseOneSignature(s string) (sig signature, rest string, err error) {
@@ -73,6 +81,7 @@ func parseOneSignature(s string) (sig signature, rest string, err error) {
 		'x', 't', // 64-bit
 		'd',           // float
 		's', 'o', 'g', // string
+		'h', // file descriptor
 		'v':
 		return basicSig(s[0]), s[1:], nil
 	case '(':
@@ -89,10 +98,29 @@ func parseOneSignature(s string) (sig signature, rest string, err error) {
 		if len(s) == 0 || s[0] != ')' {
 			return nil, "", errMissingCloseParen
 		}
+		if len(sigs) == 0 {
+			return nil, "", errEmptyStruct
+		}
 		return structSig(sigs), s[1:], nil
 	case 'a':
 		if len(s) > 1 && s[1] == '{' {
 			// Dictionary.
+			key, rest, err := parseOneSignature(s[2:])
+			if err != nil {
+				return nil, "", err
+			}
+			keysig, ok := key.(basicSig)
+			if !ok || keysig == 'v' {
+				return nil, "", fmt.Errorf("invalid dict key type %q", key.String())
+			}
+			value, rest, err := parseOneSignature(rest)
+			if err != nil {
+				return nil, "", err
+			}
+			if len(rest) == 0 || rest[0] != '}' {
+				return nil, "", errMissingCloseBrace
+			}
+			return dictSig{Key: keysig, Value: value}, rest[1:], nil
 		} else {
 			elem, rest, err := parseOneSignature(s[1:])
 			if err != nil {
@@ -104,6 +132,34 @@ func parseOneSignature(s string) (sig signature, rest string, err error) {
 	return nil, "", fmt.Errorf("invalid signature %q", s)
 }
 
+// A Signature is a D-Bus type signature, made of a sequence
+// of complete types.
+type Signature string
+
+var (
+	signatureType = reflect.TypeOf(Signature(""))
+	fileType      = reflect.TypeOf((*os.File)(nil))
+)
+
+// Parse checks that s is a valid signature.
+func (s Signature) Parse() error {
+	_, err := parseSignature(string(s))
+	return err
+}
+
+// Elements splits s into its complete types.
+func (s Signature) Elements() ([]Signature, error) {
+	sigs, err := parseSignature(string(s))
+	if err != nil {
+		return nil, err
+	}
+	elems := make([]Signature, len(sigs))
+	for i, sig := range sigs {
+		elems[i] = Signature(sig.String())
+	}
+	return elems, nil
+}
+
 func mustParseSig(s string) signature {
 	sig, rest, err := parseOneSignature(s)
 	if err != nil {
@@ -133,48 +189,128 @@ func (err *errOutOfRange) Error() string {
 	return fmt.Sprintf("message index out of range (%d/%d)", err.Offset+1, err.Length)
 }
 
+// errUnknownType reports a type code that cannot be decoded or encoded.
+type errUnknownType byte
+
+func (e errUnknownType) Error() string {
+	return fmt.Sprintf("unknown type %q", byte(e))
+}
+
+// errVariantSig reports a variant signature that is not a single
+// complete type.
+type errVariantSig string
+
+func (e errVariantSig) Error() string {
+	return fmt.Sprintf("variant signature %q is not a single type", string(e))
+}
+
+// errSignature reports an invalid signature given for decoding.
+type errSignature struct {
+	Sig string
+	E   error
+}
+
+func (e errSignature) Error() string {
+	return fmt.Sprintf("cannot decode signature %q: %s", e.Sig, e.E)
+}
+
+func (e errSignature) Unwrap() error { return e.E }
+
+// appendArray appends an array whose elements, put by proc, are
+// aligned on align bytes. The padding between the length and the
+// first element is not counted in the array length.
 func appendArray(msg *msgData, align int, proc func(*msgData)) {
 	var buf [4]byte
 	msg.Round(4)
-	msg.Round(align)
+	lengthIdx := msg.Idx
 	msg.Put(buf[:4])
+	msg.Put(buf[:alignPadding(msg.Idx, align)])
 	start := msg.Idx
 	proc(msg)
 	length := msg.Idx - start
-	msg.ByteOrder.PutUint32(msg.Data[start-4:start], uint32(length))
+	msg.patchUint32(lengthIdx, uint32(length))
+}
+
+// alignment returns the alignment of values of type sig.
+func alignment(sig signature) int {
+	switch sig := sig.(type) {
+	case arraySig:
+		return 4
+	case structSig, dictSig:
+		return 8
+	case basicSig:
+		switch sig {
+		case 'y', 'g', 'v':
+			return 1
+		case 'n', 'q':
+			return 2
+		case 'x', 't', 'd':
+			return 8
+		}
+	}
+	return 4
+}
+
+// alignPadding returns the number of bytes needed to align idx
+// on align bytes.
+func alignPadding(idx, align int) int {
+	return (align - idx%align) % align
 }
 
 func appendValue(msg *msgData, sig signature, val interface{}) (err error) {
+	// Values of the wrong type fail type assertions.
+	defer catchPanicErr(&err)
 	var buf [8]byte
 	// complex types.
 	switch sig := sig.(type) {
 	case basicSig:
 		break
 	case arraySig:
-		vals := val.([]interface{})
-		appendArray(msg, 1, func(msg *msgData) {
-			for _, v := range vals {
-				appendValue(msg, sig.Elem, v)
+		vals, ok := val.([]interface{})
+		if !ok {
+			return msg.putValue(sig, reflect.ValueOf(val))
+		}
+		appendArray(msg, alignment(sig.Elem), func(msg *msgData) {
+			for i := 0; i < len(vals) && err == nil; i++ {
+				err = appendValue(msg, sig.Elem, vals[i])
 			}
 		})
-		return nil
+		return err
 	case dictSig:
-		vals := val.([]interface{})
-		appendArray(msg, 1, func(msg *msgData) {
-			for _, v := range vals {
-				v := v.([]interface{})
-				key, value := v[0], v[1]
+		vals, ok := val.([]interface{})
+		if !ok {
+			return msg.putValue(sig, reflect.ValueOf(val))
+		}
+		appendArray(msg, 8, func(msg *msgData) {
+			for i := 0; i < len(vals) && err == nil; i++ {
+				v, ok := vals[i].([]interface{})
+				if !ok || len(v) != 2 {
+					err = fmt.Errorf("dict entry %#v is not a key/value pair", vals[i])
+					return
+				}
 				msg.Round(8)
-				appendValue(msg, sig.Key, key)
-				appendValue(msg, sig.Value, value)
+				if err = appendValue(msg, sig.Key, v[0]); err == nil {
+					err = appendValue(msg, sig.Value, v[1])
+				}
 			}
 		})
-		return nil
+		return err
 	case structSig:
+		if len(sig) == 0 {
+			return errEmptyStruct
+		}
+		vals, ok := val.([]interface{})
+		if !ok {
+			return msg.putValue(sig, reflect.ValueOf(val))
+		}
+		if len(vals) != len(sig) {
+			return fmt.Errorf("got %d values for structure %s", len(vals), sig)
+		}
 		msg.Round(8)
-		vals := val.([]interface{})
 		for i, fldsig := range sig {
-			appendValue(msg, fldsig, vals[i])
+			if err = appendValue(msg, fldsig, vals[i]); err != nil {
+				return err
+			}
 		}
 		return nil
 	default:
@@ -186,15 +322,32 @@ func appendValue(msg *msgData, sig signature, val interface{}) (err error) {
 		buf[0] = val.(byte)
 		msg.Put(buf[:1])
 
-	case 's': // string
+	case 'b': // bool
+		if val.(bool) {
+			buf[0] = 1
+		}
 		msg.Round(4)
-		s := val.(string)
+		msg.Put(buf[:4])
+
+	case 'n': // int16
+		msg.Round(2)
+		msg.ByteOrder.PutUint16(buf[:2], uint16(val.(int16)))
+		msg.Put(buf[:2])
+
+	case 'q': // uint16
+		msg.Round(2)
+		msg.ByteOrder.PutUint16(buf[:2], val.(uint16))
+		msg.Put(buf[:2])
+
+	case 's', 'o': // string, object path
+		msg.Round(4)
+		s := stringValue(val)
 		msg.ByteOrder.PutUint32(buf[:4], uint32(len(s)))
 		msg.Put(buf[:4])
 		msg.PutString(s)
 		msg.Put(buf[4:5]) // NUL.
 
-	case 'u': // uint32
+	case 'u', 'h': // uint32, file descriptor index
 		msg.Round(4)
 		msg.ByteOrder.PutUint32(buf[:4], val.(uint32))
 		msg.Put(buf[:4])
@@ -203,6 +356,36 @@ func appendValue(msg *msgData, sig signature, val interface{}) (err error) {
 		msg.Round(4)
 		msg.ByteOrder.PutUint32(buf[:4], uint32(val.(int32)))
 		msg.Put(buf[:4])
+
+	case 't': // uint64, or a timestamp in microseconds
+		msg.Round(8)
+		switch v := val.(type) {
+		case time.Time:
+			msg.ByteOrder.PutUint64(buf[:], MicrosFromTime(v))
+		default:
+			msg.ByteOrder.PutUint64(buf[:], val.(uint64))
+		}
+		msg.Put(buf[:8])
+
+	case 'x': // int64
+		msg.Round(8)
+		msg.ByteOrder.PutUint64(buf[:], uint64(val.(int64)))
+		msg.Put(buf[:8])
+
+	case 'd': // double
+		msg.Round(8)
+		msg.ByteOrder.PutUint64(buf[:], math.Float64bits(val.(float64)))
+		msg.Put(buf[:8])
+
+	case 'g': // signature
+		s := stringValue(val)
+		buf[0] = byte(len(s))
+		msg.Put(buf[:1])
+		msg.PutString(s)
+		msg.Put(buf[4:5]) // NUL.
+
+	case 'v': // variant
+		return appendVariant(msg, val)
 	default:
 		return fmt.Errorf("unsupported type %q", byte(sig))
 	}
@@ -219,14 +402,126 @@ func _GetVariant(buff []byte, index int) (vals []interface{}, retidx int, e erro
 	return
 }
 
+// Parse decodes the values of signature sig from buff, starting at
+// index, and returns them with the index following the last value.
+// Arrays, structures and dictionaries are decoded as []interface{},
+// a dictionary entry being a []interface{} of its key and value.
+// Object paths are decoded as ObjectPath, and variants as their
+// contained value.
+//
+// The decoded values marshal again to the same bytes, except for
+// variants holding signatures, structures, dictionaries or empty
+// arrays, whose type cannot be told from the decoded value.
 func Parse(buff []byte, sig string, index int) (slice []interface{}, bufIdx int, err error) {
 	msg := &msgData{ByteOrder: binary.LittleEndian, Data: buff, Idx: index}
+	slice, err = msg.parse(sig)
+	return slice, msg.Idx, err
+}
+
+// DecodeBody decodes a message body of signature sig, encoded with
+// the given byte order. There is one value per complete type of sig.
+// Basic types are decoded to the matching Go types. Arrays and
+// structs are decoded as []interface{}, and dicts as []interface{}
+// holding []interface{}{key, value} pairs, in wire order. Variants
+// are decoded as the value they hold.
+func DecodeBody(sig string, body []byte, order binary.ByteOrder) ([]interface{}, error) {
+	msg := &msgData{ByteOrder: order, Data: body}
+	return msg.parse(sig)
+}
+
+type errShortBody struct {
+	Sig string
+	E   error
+}
+
+func (e errShortBody) Error() string {
+	return fmt.Sprintf("message body too short for signature %q: %s", e.Sig, e.E)
+}
+
+func (e errShortBody) Unwrap() error { return e.E }
+
+// parse decodes values according to sig. Reading past the end
+// of data is reported as an errShortBody, while trailing bytes
+// are left unread.
+func (msg *msgData) parse(sig string) (slice []interface{}, err error) {
+	defer func() {
+		if e, ok := err.(*errOutOfRange); ok {
+			err = errShortBody{Sig: sig, E: e}
+		}
+	}()
+	defer catchPanicErr(&err)
+	if isBasicSignature(sig) {
+		return msg.parseBasics(sig)
+	}
 	sigs, err := parseSignature(sig)
 	if err != nil {
-		return
+		return nil, errSignature{Sig: sig, E: err}
 	}
-	slice, err = parseVariants(msg, sigs)
-	return slice, msg.Idx, err
+	return parseVariants(msg, sigs)
+}
+
+// isBasicSignature reports whether sig is a sequence of basic
+// types, other than variants.
+func isBasicSignature(sig string) bool {
+	for i := 0; i < len(sig); i++ {
+		if strings.IndexByte("ybnqiuxtdsogh", sig[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// parseBasics decodes a sequence of basic types without parsing
+// the signature.
+func (msg *msgData) parseBasics(sig string) ([]interface{}, error) {
+	slice := make([]interface{}, 0, len(sig))
+	for i := 0; i < len(sig); i++ {
+		val, err := msg.parseBasic(basicSig(sig[i]))
+		if err != nil {
+			return nil, err
+		}
+		slice = append(slice, val)
+	}
+	return slice, nil
+}
+
+// AsObjectPaths converts a decoded array of object paths or strings
+// (signatures ao or as) to a []string.
+func AsObjectPaths(v interface{}) ([]string, error) {
+	vals, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an array, got %T", v)
+	}
+	paths := make([]string, len(vals))
+	for i, val := range vals {
+		switch s := val.(type) {
+		case string:
+			paths[i] = s
+		case ObjectPath:
+			paths[i] = string(s)
+		default:
+			return nil, fmt.Errorf("expected a string at index %d, got %T", i, val)
+		}
+	}
+	return paths, nil
+}
+
+// AsStrings converts a decoded array of strings (signature as),
+// such as a list of bus names, to a []string.
+func AsStrings(v interface{}) ([]string, error) {
+	vals, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an array, got %T", v)
+	}
+	strs := make([]string, len(vals))
+	for i, val := range vals {
+		s, ok := val.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a string at index %d, got %T", i, val)
+		}
+		strs[i] = s
+	}
+	return strs, nil
 }
 
 func parseVariants(msg *msgData, sigs []signature) (slice []interface{}, err error) {
@@ -239,6 +534,9 @@ func parseVariants(msg *msgData, sigs []signature) (slice []interface{}, err err
 			msg.Round(4)
 			// length in bytes.
 			l := msg.ByteOrder.Uint32(msg.Next(4))
+			// the length does not count the padding before
+			// the first element.
+			msg.Round(alignment(sig.Elem))
 			end := msg.Idx + int(l)
 			tmpSlice := make([]interface{}, 0)
 			var arrValues []interface{}
@@ -265,8 +563,10 @@ func parseVariants(msg *msgData, sigs []signature) (slice []interface{}, err err
 			msg.Round(4)
 			// length in bytes.
 			l := msg.ByteOrder.Uint32(msg.Next(4))
+			// dict entries are aligned on 8 bytes.
+			msg.Round(8)
 			end := msg.Idx + int(l)
-			var dictVals []interface{}
+			dictVals := make([]interface{}, 0)
 			elemsig := []signature{sig.Key, sig.Value}
 			for msg.Idx < end {
 				msg.Round(8)
@@ -281,57 +581,119 @@ func parseVariants(msg *msgData, sigs []signature) (slice []interface{}, err err
 		default:
 			panic(fmt.Errorf("invalid signature type %T", sig))
 		}
-		switch sig := sig.(basicSig); sig {
-		case 'b': // bool
-			msg.Round(4)
-			x := msg.ByteOrder.Uint32(msg.Next(4))
-			slice = append(slice, bool(x != 0))
+		if sig == basicSig('v') {
+			// A variant is a single value.
+			val, e := msg.parseVariant()
+			if e != nil {
+				return nil, e
+			}
+			slice = append(slice, val)
+			continue
+		}
+		val, e := msg.parseBasic(sig.(basicSig))
+		if e != nil {
+			return nil, e
+		}
+		slice = append(slice, val)
+	}
+	return
+}
 
-		case 'y': // byte
-			slice = append(slice, msg.Data[msg.Idx])
-			msg.Idx++
+// parseVariant decodes a variant: its signature and the contained
+// value.
+func (msg *msgData) parseVariant() (interface{}, error) {
+	l := msg.Next(1)[0]
+	s := msg.Next(int(l) + 1)
+	sig, rest, err := parseOneSignature(string(s[:l]))
+	if err == nil && rest != "" {
+		err = errVariantSig(s[:l])
+	}
+	if err != nil {
+		return nil, err
+	}
+	vals, err := parseVariants(msg, []signature{sig})
+	if err != nil {
+		return nil, err
+	}
+	return vals[0], nil
+}
 
-		case 'n': // int16
-			msg.Round(2)
-			x := msg.ByteOrder.Uint16(msg.Next(2))
-			slice = append(slice, int16(x))
+// parseBasic decodes a value of basic type sig.
+func (msg *msgData) parseBasic(sig basicSig) (interface{}, error) {
+	switch sig {
+	case 'b': // bool
+		msg.Round(4)
+		x := msg.ByteOrder.Uint32(msg.Next(4))
+		return bool(x != 0), nil
 
-		case 'q': // uint16
-			msg.Round(2)
-			x := msg.ByteOrder.Uint16(msg.Next(2))
-			slice = append(slice, uint16(x))
+	case 'y': // byte
+		return msg.Next(1)[0], nil
 
-		case 'u': // uint32
-			msg.Round(4)
-			x := msg.ByteOrder.Uint32(msg.Next(4))
-			slice = append(slice, uint32(x))
+	case 'n': // int16
+		msg.Round(2)
+		x := msg.ByteOrder.Uint16(msg.Next(2))
+		return int16(x), nil
 
-		case 's', 'o': // string, object
-			msg.Round(4)
-			l := msg.ByteOrder.Uint32(msg.Next(4))
-			s := msg.Next(int(l) + 1)
-			slice = append(slice, string(s[:l]))
+	case 'q': // uint16
+		msg.Round(2)
+		x := msg.ByteOrder.Uint16(msg.Next(2))
+		return uint16(x), nil
 
-		case 'g': // signature
-			l := msg.Next(1)[0]
-			s := msg.Next(int(l) + 1)
-			slice = append(slice, string(s[:l]))
+	case 'i': // int32
+		msg.Round(4)
+		x := msg.ByteOrder.Uint32(msg.Next(4))
+		return int32(x), nil
 
-		case 'v': // variant
-			vals, idx, e := _GetVariant(msg.Data, msg.Idx)
-			msg.Idx = idx
-			if e != nil {
-				err = e
-				return
-			}
-			slice = append(slice, vals...)
+	case 'u': // uint32
+		msg.Round(4)
+		x := msg.ByteOrder.Uint32(msg.Next(4))
+		return uint32(x), nil
 
-		default:
-			fmt.Println(sig)
-			return nil, errors.New("unknown type")
+	case 'x': // int64
+		msg.Round(8)
+		x := msg.ByteOrder.Uint64(msg.Next(8))
+		return int64(x), nil
+
+	case 't': // uint64
+		msg.Round(8)
+		x := msg.ByteOrder.Uint64(msg.Next(8))
+		return x, nil
+
+	case 'd': // double
+		msg.Round(8)
+		x := msg.ByteOrder.Uint64(msg.Next(8))
+		return math.Float64frombits(x), nil
+
+	case 's', 'o': // string, object
+		msg.Round(4)
+		l := msg.ByteOrder.Uint32(msg.Next(4))
+		s := msg.Next(int(l) + 1)
+		if err := msg.checkString(sig, s[:l]); err != nil {
+			return nil, err
+		}
+		if sig == 'o' {
+			return ObjectPath(s[:l]), nil
+		}
+		return string(s[:l]), nil
+
+	case 'g': // signature
+		l := msg.Next(1)[0]
+		s := msg.Next(int(l) + 1)
+		if err := msg.checkString(sig, s[:l]); err != nil {
+			return nil, err
+		}
+		return string(s[:l]), nil
+
+	case 'h': // file descriptor
+		msg.Round(4)
+		x := msg.ByteOrder.Uint32(msg.Next(4))
+		f, e := msg.file(x)
+		if e != nil {
+			return nil, e
 		}
+		return f, nil
 	}
-	return
+	return nil, errUnknownType(sig)
 }
 
 // The D-Bus message header. A message consists of this and an array
@@ -362,8 +724,86 @@ type msgHeaderFields struct {
 type msgData struct {
 	ByteOrder binary.ByteOrder
 
-	Data []byte
-	Idx  int
+	Data  []byte
+	Idx   int
+	Files []*os.File // file descriptors for 'h' values.
+
+	// StrictHeader rejects header fields unknown to
+	// the specification instead of skipping them.
+	StrictHeader bool
+	// SizeOnly makes Put only advance Idx, to compute
+	// the size of marshalled data.
+	SizeOnly bool
+	// StrictStrings rejects strings which are not valid UTF-8,
+	// and malformed object paths and signatures.
+	StrictStrings bool
+}
+
+type errInvalidString struct {
+	Sig   basicSig
+	Value string
+}
+
+func (e errInvalidString) Error() string {
+	kind := "string"
+	switch e.Sig {
+	case 'o':
+		kind = "object path"
+	case 'g':
+		kind = "signature"
+	}
+	return fmt.Sprintf("invalid %s %q", kind, e.Value)
+}
+
+// checkString validates a decoded string of type sig
+// if StrictStrings is set.
+func (msg *msgData) checkString(sig basicSig, s []byte) error {
+	if !msg.StrictStrings {
+		return nil
+	}
+	valid := utf8.Valid(s) && bytes.IndexByte(s, 0) < 0
+	switch sig {
+	case 'o':
+		valid = validObjectPath(string(s))
+	case 'g':
+		_, err := parseSignature(string(s))
+		valid = valid && err == nil
+	}
+	if !valid {
+		return errInvalidString{Sig: sig, Value: string(s)}
+	}
+	return nil
+}
+
+// validObjectPath reports whether path is a valid object path:
+// "/", or elements of [A-Za-z0-9_] each preceded by a slash.
+func validObjectPath(path string) bool {
+	if path == "/" {
+		return true
+	}
+	if len(path) < 2 || path[0] != '/' || path[len(path)-1] == '/' {
+		return false
+	}
+	for i := 1; i < len(path); i++ {
+		switch c := path[i]; {
+		case c == '/':
+			if path[i-1] == '/' {
+				return false
+			}
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// file returns the file descriptor at index idx as an *os.File.
+func (msg *msgData) file(idx uint32) (*os.File, error) {
+	if int(idx) >= len(msg.Files) {
+		return nil, fmt.Errorf("file descriptor index %d out of range (%d received)", idx, len(msg.Files))
+	}
+	return msg.Files[idx], nil
 }
 
 func (msg *msgData) Round(rnd int) {
@@ -390,6 +830,10 @@ func (msg *msgData) Next(n int) []byte {
 }
 
 func (msg *msgData) Put(s []byte) {
+	if msg.SizeOnly {
+		msg.Idx += len(s)
+		return
+	}
 	if msg.Idx >= cap(msg.Data) {
 		newdata := make([]byte, len(msg.Data), msg.Idx+len(msg.Data)/4)
 		copy(newdata, msg.Data)
@@ -400,6 +844,10 @@ func (msg *msgData) Put(s []byte) {
 }
 
 func (msg *msgData) PutString(s string) {
+	if msg.SizeOnly {
+		msg.Idx += len(s)
+		return
+	}
 	if msg.Idx >= cap(msg.Data) {
 		newdata := make([]byte, len(msg.Data), msg.Idx+len(msg.Data)/4)
 		copy(newdata, msg.Data)
@@ -409,6 +857,13 @@ func (msg *msgData) PutString(s string) {
 	msg.Idx += len(s)
 }
 
+// patchUint32 writes x at offset idx, which has already been put.
+func (msg *msgData) patchUint32(idx int, x uint32) {
+	if !msg.SizeOnly {
+		msg.ByteOrder.PutUint32(msg.Data[idx:idx+4], x)
+	}
+}
+
 func (msg *msgData) scanHeader() (hdr msgHeader, flds msgHeaderFields, err error) {
 	defer catchPanicErr(&err)
 	// The fixed header.
@@ -421,23 +876,82 @@ func (msg *msgData) scanHeader() (hdr msgHeader, flds msgHeaderFields, err error
 	for msg.Idx < fldEnd {
 		// A field is a struct byte + variant, hence aligned on 8 bytes.
 		msg.Round(8)
+		start := msg.Idx
 		b := msg.Next(1)[0]
+		if b == 0 || (b > 9 && msg.StrictHeader) {
+			err = errHeaderFieldID(b)
+			return
+		}
 		if b > 9 {
-			err = fmt.Errorf("invalid header field ID: %d", b)
+			// Unknown fields are skipped.
+			if _, err = msg.parseVariant(); err != nil {
+				return
+			}
+		} else {
+			// A variant is a signature and value.
+			var fldSig string
+			if err = msg.scan("g", &fldSig); err != nil {
+				return
+			}
+			if fldSig != fldSigs[b-1].String() {
+				err = errHeaderFieldSig{Field: b, Sig: fldSig}
+				return
+			}
+			if err = msg.scan(fldSig, fldVal.Field(int(b)-1).Addr().Interface()); err != nil {
+				return
+			}
+		}
+		if msg.Idx <= start || msg.Idx > fldEnd {
+			err = errHeaderFieldBounds{Field: b, End: msg.Idx, Limit: fldEnd}
 			return
 		}
-		// A variant is a signature and value.
-		var fldSig string
-		msg.scan("g", &fldSig)
-		msg.scan(fldSig, fldVal.Field(int(b)-1).Addr().Interface())
 	}
 	return
 }
 
+type errHeaderFieldID byte
+
+func (e errHeaderFieldID) Error() string {
+	return fmt.Sprintf("invalid header field ID: %d", byte(e))
+}
+
+type errHeaderFieldBounds struct {
+	Field      byte
+	End, Limit int
+}
+
+func (e errHeaderFieldBounds) Error() string {
+	return fmt.Sprintf("header field %s (%d) ends at offset %d, past the end of header fields at %d",
+		fieldName(e.Field), e.Field, e.End, e.Limit)
+}
+
 // the Dbus signatures for msgHeader and msgHeaderFields.
 var hdrSigs = mustParseSig("(yyyyuu)")
 var fldSigs = mustParseSigs("osssussgu")
 
+func fieldName(b byte) string {
+	if b == 0 || int(b) > len(fldNames) {
+		return "UNKNOWN"
+	}
+	return fldNames[b-1]
+}
+
+// the names of header fields, indexed by field ID - 1.
+var fldNames = [...]string{
+	"PATH", "INTERFACE", "MEMBER", "ERROR_NAME", "REPLY_SERIAL",
+	"DESTINATION", "SENDER", "SIGNATURE", "UNIX_FDS",
+}
+
+type errHeaderFieldSig struct {
+	Field byte
+	Sig   string
+}
+
+func (e errHeaderFieldSig) Error() string {
+	return fmt.Sprintf("header field %s (%d) has signature %q, expected %q",
+		fldNames[e.Field-1], e.Field, e.Sig, fldSigs[e.Field-1].String())
+}
+
 func (msg *msgData) putHeader(hdr msgHeader, flds msgHeaderFields) (err error) {
 	defer catchPanicErr(&err)
 	var buf [8]byte
@@ -461,7 +975,7 @@ func (msg *msgData) putHeader(hdr msgHeader, flds msgHeaderFields) (err error) {
 		msg.putValue(fldSig, elem)
 	}
 	length := msg.Idx - fldStart
-	msg.ByteOrder.PutUint32(msg.Data[fldStart-4:fldStart], uint32(length))
+	msg.patchUint32(fldStart-4, uint32(length))
 	return nil
 }
 
@@ -494,10 +1008,12 @@ func (msg *msgData) put(sigstr string, val interface{}) (err error) {
 func (msg *msgData) scanMany(s string, val ...reflect.Value) (err error) {
 	sigs, err := parseSignature(s)
 	if err != nil {
-		return err
+		return errSignature{Sig: s, E: err}
 	}
 	for i, sig := range sigs {
-		msg.scanValue(sig, val[i])
+		if err = msg.scanValue(sig, val[i]); err != nil {
+			return
+		}
 	}
 	return
 }
@@ -507,6 +1023,22 @@ func (msg *msgData) scanMany(s string, val ...reflect.Value) (err error) {
 // http://dbus.freedesktop.org/doc/dbus-specification.html#type-system
 func (msg *msgData) scanValue(sig signature, val reflect.Value) (err error) {
 	defer catchPanicErr(&err)
+	// Allocate and dereference pointer destinations.
+	for val.Kind() == reflect.Ptr && val.Type() != fileType {
+		if val.IsNil() {
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		val = val.Elem()
+	}
+	if val.Kind() == reflect.Interface && val.NumMethod() == 0 {
+		// Decode to the same types as Parse.
+		vals, err := parseVariants(msg, []signature{sig})
+		if err != nil {
+			return err
+		}
+		val.Set(reflect.ValueOf(vals[0]))
+		return nil
+	}
 	switch sig := sig.(type) {
 	case basicSig:
 		break
@@ -514,20 +1046,62 @@ func (msg *msgData) scanValue(sig signature, val reflect.Value) (err error) {
 		msg.Round(4)
 		// length in bytes.
 		l := msg.ByteOrder.Uint32(msg.Next(4))
+		msg.Round(alignment(sig.Elem))
 		end := msg.Idx + int(l)
 		for msg.Idx < end {
 			elemval := reflect.New(val.Type().Elem()).Elem()
-			_ = msg.scanValue(sig.Elem, elemval)
+			if err = msg.scanValue(sig.Elem, elemval); err != nil {
+				return err
+			}
 			v := reflect.Append(val, elemval)
 			val.Set(v)
 		}
+		return nil
 	case structSig:
+		if val.Kind() != reflect.Struct {
+			return fmt.Errorf("cannot decode structure %s into %s", sig, val.Type())
+		}
 		msg.Round(8)
+		fields, err := structFields(val.Type(), len(sig))
+		if err != nil {
+			return err
+		}
 		for i, fldsig := range sig {
-			msg.scanValue(fldsig, val.Field(i))
+			var fld reflect.Value
+			if fields[i] < 0 {
+				// decode and discard.
+				fld = reflect.New(discardType(fldsig)).Elem()
+			} else {
+				fld = val.Field(fields[i])
+			}
+			if err = msg.scanValue(fldsig, fld); err != nil {
+				return err
+			}
 		}
+		return nil
 	case dictSig:
-		panic("unsupported dictionaries")
+		msg.Round(4)
+		// length in bytes.
+		l := msg.ByteOrder.Uint32(msg.Next(4))
+		// dict entries are aligned on 8 bytes.
+		msg.Round(8)
+		end := msg.Idx + int(l)
+		if val.IsNil() {
+			val.Set(reflect.MakeMap(val.Type()))
+		}
+		for msg.Idx < end {
+			msg.Round(8)
+			key := reflect.New(val.Type().Key()).Elem()
+			if err = msg.scanValue(sig.Key, key); err != nil {
+				return err
+			}
+			elem := reflect.New(val.Type().Elem()).Elem()
+			if err = msg.scanValue(sig.Value, elem); err != nil {
+				return err
+			}
+			val.SetMapIndex(key, elem)
+		}
+		return nil
 	default:
 		panic("impossible signature type")
 	}
@@ -544,7 +1118,7 @@ func (msg *msgData) scanValue(sig signature, val reflect.Value) (err error) {
 	case 'n': // int16
 		msg.Round(2)
 		x := msg.ByteOrder.Uint16(msg.Next(2))
-		val.SetInt(int64(x))
+		val.SetInt(int64(int16(x)))
 	case 'q': // uint16
 		msg.Round(2)
 		x := msg.ByteOrder.Uint16(msg.Next(2))
@@ -553,7 +1127,7 @@ func (msg *msgData) scanValue(sig signature, val reflect.Value) (err error) {
 	case 'i': // int32
 		msg.Round(4)
 		x := msg.ByteOrder.Uint32(msg.Next(4))
-		val.SetInt(int64(x))
+		val.SetInt(int64(int32(x)))
 	case 'u': // uint32
 		msg.Round(4)
 		x := msg.ByteOrder.Uint32(msg.Next(4))
@@ -564,7 +1138,7 @@ func (msg *msgData) scanValue(sig signature, val reflect.Value) (err error) {
 		x := msg.ByteOrder.Uint64(msg.Next(8))
 		val.SetInt(int64(x))
 	case 't': // uint64
-		msg.Round(4)
+		msg.Round(8)
 		x := msg.ByteOrder.Uint64(msg.Next(8))
 		val.SetUint(x)
 	case 'd': // double
@@ -576,17 +1150,35 @@ func (msg *msgData) scanValue(sig signature, val reflect.Value) (err error) {
 		msg.Round(4)
 		l := msg.ByteOrder.Uint32(msg.Next(4))
 		s := msg.Next(int(l) + 1)
+		if err := msg.checkString(sig.(basicSig), s[:l]); err != nil {
+			return err
+		}
 		val.SetString(string(s[:l]))
 
 	case 'g': // signature string
 		l := msg.Next(1)[0]
 		s := msg.Next(int(l) + 1)
+		if err := msg.checkString('g', s[:l]); err != nil {
+			return err
+		}
+		if val.Type() == signatureType {
+			if err := Signature(s[:l]).Parse(); err != nil {
+				return err
+			}
+		}
 		val.SetString(string(s[:l]))
 
+	case 'h': // file descriptor
+		msg.Round(4)
+		x := msg.ByteOrder.Uint32(msg.Next(4))
+		f, err := msg.file(x)
+		if err != nil {
+			return err
+		}
+		val.Set(reflect.ValueOf(f))
+
 	default:
-		panic("unsupported")
-		//case '{': // dict
-		//case 'h': // file descriptor
+		return errUnknownType(sig.(basicSig))
 	}
 	return nil
 }
@@ -595,32 +1187,57 @@ func (msg *msgData) putValue(sig signature, val reflect.Value) (err error) {
 	defer catchPanicErr(&err)
 	var buf [8]byte
 
+	if val.Kind() == reflect.Interface && sig != basicSig('v') {
+		val = val.Elem()
+	}
 	switch sig := sig.(type) {
 	case basicSig:
 		break
 	case arraySig:
-		msg.Round(4)
-		// length in bytes.
-		idx := msg.Idx
-		msg.Put(buf[:4])
-		begin := msg.Idx
-		for i, imax := 0, val.Len(); i < imax; i++ {
-			elem := val.Index(i)
-			msg.putValue(sig.Elem, elem)
-		}
-		length := msg.Idx - begin
-		msg.ByteOrder.PutUint32(msg.Data[idx:idx+4], uint32(length))
+		appendArray(msg, alignment(sig.Elem), func(msg *msgData) {
+			for i, imax := 0, val.Len(); i < imax && err == nil; i++ {
+				err = msg.putValue(sig.Elem, val.Index(i))
+			}
+		})
+		return err
 
 	case structSig:
+		if len(sig) == 0 {
+			return errEmptyStruct
+		}
+		if val.Kind() != reflect.Struct {
+			return fmt.Errorf("cannot encode %s as structure %s", val.Type(), sig)
+		}
+		fields, err := structFields(val.Type(), len(sig))
+		if err != nil {
+			return err
+		}
 		msg.Round(8)
 		for i, fldsig := range sig {
-			fld := val.Field(i)
-			msg.putValue(fldsig, fld)
+			if fields[i] < 0 {
+				return fmt.Errorf("struct %s has no field for position %d", val.Type(), i)
+			}
+			if err = msg.putValue(fldsig, val.Field(fields[i])); err != nil {
+				return err
+			}
 		}
+		return nil
 	case dictSig:
-		panic("dictionaries are unsupported")
+		if val.Kind() != reflect.Map {
+			return fmt.Errorf("cannot encode %s as dictionary %s", val.Type(), sig)
+		}
+		keys := val.MapKeys()
+		appendArray(msg, 8, func(msg *msgData) {
+			for i := 0; i < len(keys) && err == nil; i++ {
+				msg.Round(8)
+				if err = msg.putValue(sig.Key, keys[i]); err == nil {
+					err = msg.putValue(sig.Value, val.MapIndex(keys[i]))
+				}
+			}
+		})
+		return err
 	default:
-		panic("impossible signature type")
+		return fmt.Errorf("invalid signature type %T", sig)
 	}
 	switch sig.(basicSig) {
 	case 'y': // byte
@@ -647,7 +1264,7 @@ func (msg *msgData) putValue(sig signature, val reflect.Value) (err error) {
 		msg.Round(4)
 		msg.ByteOrder.PutUint32(buf[:], uint32(val.Int()))
 		msg.Put(buf[:4])
-	case 'u': // uint32
+	case 'u', 'h': // uint32, file descriptor index
 		msg.Round(4)
 		msg.ByteOrder.PutUint32(buf[:], uint32(val.Uint()))
 		msg.Put(buf[:4])
@@ -658,7 +1275,11 @@ func (msg *msgData) putValue(sig signature, val reflect.Value) (err error) {
 		msg.Put(buf[:8])
 	case 't': // uint64
 		msg.Round(8)
-		msg.ByteOrder.PutUint64(buf[:], val.Uint())
+		if val.Type() == timeType {
+			msg.ByteOrder.PutUint64(buf[:], MicrosFromTime(val.Interface().(time.Time)))
+		} else {
+			msg.ByteOrder.PutUint64(buf[:], val.Uint())
+		}
 		msg.Put(buf[:8])
 	case 'd': // double
 		msg.Round(8)
@@ -685,14 +1306,101 @@ func (msg *msgData) putValue(sig signature, val reflect.Value) (err error) {
 		msg.PutString(s)
 		msg.Put(buf[1:2]) // NUL
 
+	case 'v': // variant
+		return appendVariant(msg, val.Interface())
+
 	default:
-		panic("unsupported")
-		//case '{': // dict
-		//case 'h': // file descriptor
+		return errUnknownType(sig.(basicSig))
 	}
 	return nil
 }
 
+// structFields maps the n fields of a D-Bus struct to the fields of
+// the Go struct type t, returning -1 for D-Bus fields to be discarded.
+// Go fields match D-Bus fields by position. The position of a field can
+// be set explicitly with a `dbus:"N"` tag, and fields tagged `dbus:"-"`
+// are ignored, their D-Bus counterpart being discarded. Without tags,
+// the Go struct must have exactly n fields.
+func structFields(t reflect.Type, n int) ([]int, error) {
+	fields := make([]int, n)
+	for i := range fields {
+		fields[i] = -1
+	}
+	tagged := false
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("dbus") != "" {
+			tagged = true
+		}
+	}
+	if !tagged && t.NumField() != n {
+		return nil, errStructFields{Type: t, Values: n}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		pos := i
+		switch tag := t.Field(i).Tag.Get("dbus"); tag {
+		case "":
+		case "-":
+			continue
+		default:
+			p, err := strconv.Atoi(tag)
+			if err != nil || p < 0 {
+				return nil, fmt.Errorf("invalid dbus tag %q on field %s", tag, t.Field(i).Name)
+			}
+			pos = p
+		}
+		if pos >= n {
+			continue
+		}
+		if fields[pos] >= 0 {
+			return nil, fmt.Errorf("fields %s and %s both map to position %d",
+				t.Field(fields[pos]).Name, t.Field(i).Name, pos)
+		}
+		fields[pos] = i
+	}
+	return fields, nil
+}
+
+type errStructFields struct {
+	Type   reflect.Type
+	Values int
+}
+
+func (e errStructFields) Error() string {
+	return fmt.Sprintf("struct %s has %d fields, D-Bus structure has %d", e.Type, e.Type.NumField(), e.Values)
+}
+
+// discardType returns a Go type able to hold a decoded value
+// of signature sig.
+func discardType(sig signature) reflect.Type {
+	switch sig := sig.(type) {
+	case arraySig:
+		return reflect.SliceOf(discardType(sig.Elem))
+	case dictSig:
+		return reflect.MapOf(discardType(sig.Key), discardType(sig.Value))
+	case structSig:
+		flds := make([]reflect.StructField, len(sig))
+		for i, s := range sig {
+			flds[i] = reflect.StructField{Name: fmt.Sprintf("F%d", i), Type: discardType(s)}
+		}
+		return reflect.StructOf(flds)
+	}
+	switch sig.(basicSig) {
+	case 'y':
+		return reflect.TypeOf(byte(0))
+	case 'b':
+		return reflect.TypeOf(false)
+	case 'n', 'i', 'x':
+		return reflect.TypeOf(int64(0))
+	case 'q', 'u', 't':
+		return reflect.TypeOf(uint64(0))
+	case 'd':
+		return reflect.TypeOf(float64(0))
+	case 'h':
+		return fileType
+	}
+	return reflect.TypeOf("")
+}
+
 func catchPanicErr(err *error) {
 	switch p := recover(); e := p.(type) {
 	case nil:
@@ -19,7 +19,8 @@ func parseSig(s string) signature {
 }
 
 func TestAppendArray(t *testing.T) {
-	teststr := "\x01\x02\x03\x04\x05\x00\x00\x00\x05\x00\x00\x00\x00\x00\x00\x00\x02"
+	// The padding after the length is not part of the array.
+	teststr := "\x01\x02\x03\x04\x05\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x02"
 
 	msg := &msgData{
 		ByteOrder: binary.LittleEndian,
@@ -27,7 +28,7 @@ func TestAppendArray(t *testing.T) {
 		Idx:       5,
 	}
 
-	appendArray(msg, 1,
+	appendArray(msg, 8,
 		func(msg *msgData) {
 			t.Log(msg.Data)
 			msg.Round(8)
@@ -41,6 +42,35 @@ func TestAppendArray(t *testing.T) {
 	}
 }
 
+func TestAppendArrayAligned(t *testing.T) {
+	// Reference encodings, as produced by libdbus.
+	tests := []struct {
+		sig  string
+		val  []interface{}
+		data string
+	}{
+		{"at", []interface{}{uint64(1)},
+			"\x08\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00"},
+		{"ax", []interface{}{int64(-1), int64(2)},
+			"\x10\x00\x00\x00\x00\x00\x00\x00\xff\xff\xff\xff\xff\xff\xff\xff\x02\x00\x00\x00\x00\x00\x00\x00"},
+		{"ad", []interface{}{1.5},
+			"\x08\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\xf8\x3f"},
+		{"at", []interface{}{},
+			"\x00\x00\x00\x00\x00\x00\x00\x00"},
+		{"a{yy}", []interface{}{[]interface{}{byte(1), byte(2)}},
+			"\x02\x00\x00\x00\x00\x00\x00\x00\x01\x02"},
+	}
+	for _, test := range tests {
+		msg := &msgData{ByteOrder: binary.LittleEndian}
+		if err := appendValue(msg, mustParseSig(test.sig), test.val); err != nil {
+			t.Fatal(err)
+		}
+		if string(msg.Data) != test.data {
+			t.Errorf("%s: got\n%q\nwant\n%q", test.sig, msg.Data, test.data)
+		}
+	}
+}
+
 func TestAppendValue(t *testing.T) {
 	buff := new(msgData)
 	buff.ByteOrder = binary.LittleEndian
@@ -58,7 +88,7 @@ func TestAppendValue(t *testing.T) {
 	slice = append(slice, []interface{}{"test2", uint32(2)})
 	slice = append(slice, []interface{}{"test3", uint32(3)})
 	appendValue(buff, parseSig("a(su)"), slice)
-	ref2 := []byte("\x34\x00\x00\x00\x00\x00\x00\x00\x05\x00\x00\x00test1\x00\x00\x00\x01\x00\x00\x00\x05\x00\x00\x00test2\x00\x00\x00\x02\x00\x00\x00\x05\x00\x00\x00test3\x00\x00\x00\x03\x00\x00\x00")
+	ref2 := []byte("\x30\x00\x00\x00\x00\x00\x00\x00\x05\x00\x00\x00test1\x00\x00\x00\x01\x00\x00\x00\x05\x00\x00\x00test2\x00\x00\x00\x02\x00\x00\x00\x05\x00\x00\x00test3\x00\x00\x00\x03\x00\x00\x00")
 	if !bytes.Equal(ref2, buff.Data) {
 		t.Errorf("got\n%q\nwant\n%q", buff.Data, ref2)
 	}
@@ -106,7 +136,8 @@ func TestParse(t *testing.T) {
 		t.Error("#3-4 Failed:")
 	}
 
-	ret, _, e := Parse([]byte("\x22\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x04\x00\x00\x00true\x00\x00\x00\x00\x00\x00\x00\x00\x05\x00\x00\x00false\x00"), "a(bs)", 0)
+	// The array length does not count the padding after it.
+	ret, _, e := Parse([]byte("\x1e\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x04\x00\x00\x00true\x00\x00\x00\x00\x00\x00\x00\x00\x05\x00\x00\x00false\x00"), "a(bs)", 0)
 	if e != nil {
 		t.Error(e.Error())
 	}
@@ -132,6 +163,306 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestParseArrayElemAlignment(t *testing.T) {
+	// at with a single element: 4 bytes of padding follow the
+	// length, which only counts the element.
+	const data = "\x08\x00\x00\x00\x00\x00\x00\x00\x01\x02\x03\x04\x05\x06\x07\x08"
+	ret, _, err := Parse([]byte(data), "at", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{[]interface{}{uint64(0x0807060504030201)}}
+	if !reflect.DeepEqual(ret, want) {
+		t.Errorf("got %#v, want %#v", ret, want)
+	}
+
+	var vals []uint64
+	msg := &msgData{ByteOrder: binary.LittleEndian, Data: []byte(data)}
+	if err := msg.scan("at", &vals); err != nil {
+		t.Fatal(err)
+	}
+	if len(vals) != 1 || vals[0] != 0x0807060504030201 {
+		t.Errorf("got %#x", vals)
+	}
+}
+
+func TestParseInt16(t *testing.T) {
+	ret, _, err := Parse([]byte("\xfe\xff\xfe\xff"), "nq", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{int16(-2), uint16(0xfffe)}; !reflect.DeepEqual(ret, want) {
+		t.Errorf("got %#v, want %#v", ret, want)
+	}
+
+	ret, _, err = Parse([]byte("\x01\x00\x00\x00\x00\x00\x00\x00"+
+		"\xff\xff\xff\xff\xff\xff\xff\xff"+
+		"\x00\x00\x00\x00\x00\x00\xf8\x3f"), "xtd", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{int64(1), uint64(1<<64 - 1), 1.5}; !reflect.DeepEqual(ret, want) {
+		t.Errorf("got %#v, want %#v", ret, want)
+	}
+}
+
+func TestParseStructScalars(t *testing.T) {
+	buf := []byte("\xff\xff\xff\xff\xfe\xff\x00\x00" +
+		"\x00\x00\x00\x00\x00\x00\xf8\x3f" +
+		"\xfd\xff\xff\xff\xff\xff\xff\xff" +
+		"\x05\x00\x00\x00\x00\x00\x00\x00")
+	ret, idx, err := Parse(buf, "(indxt)", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{[]interface{}{int32(-1), int16(-2), 1.5, int64(-3), uint64(5)}}
+	if !reflect.DeepEqual(ret, want) {
+		t.Errorf("got %#v, want %#v", ret, want)
+	}
+	if idx != len(buf) {
+		t.Errorf("got index %d, want %d", idx, len(buf))
+	}
+}
+
+func TestDecodeBody(t *testing.T) {
+	value := []interface{}{
+		[]interface{}{
+			[]interface{}{"b", uint32(2)},
+			[]interface{}{"a", "one"},
+		},
+		[]interface{}{"x", "y"},
+	}
+	want := []interface{}{[]interface{}{
+		[]interface{}{
+			[]interface{}{"b", uint32(2)},
+			[]interface{}{"a", "one"},
+		},
+		[]interface{}{"x", "y"},
+	}}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		msg := &msgData{ByteOrder: order}
+		if err := appendValue(msg, mustParseSig("(a{sv}as)"), value); err != nil {
+			t.Fatal(err)
+		}
+		got, err := DecodeBody("(a{sv}as)", msg.Data, order)
+		if err != nil {
+			t.Fatalf("%s: %s", order, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %#v, want %#v", order, got, want)
+		}
+	}
+}
+
+func TestAppendValueElemError(t *testing.T) {
+	tests := []struct {
+		sig string
+		val []interface{}
+	}{
+		{"av", []interface{}{1}},
+		{"a{sv}", []interface{}{[]interface{}{"key", struct{}{}}}},
+		{"a{sv}", []interface{}{"key"}},
+		{"(sv)", []interface{}{"key", 1}},
+		{"(su)", []interface{}{"key"}},
+	}
+	for _, test := range tests {
+		msg := &msgData{ByteOrder: binary.LittleEndian}
+		if err := appendValue(msg, mustParseSig(test.sig), test.val); err == nil {
+			t.Errorf("%s: no error encoding %#v, got %q", test.sig, test.val, msg.Data)
+		}
+	}
+
+	// The error is returned when marshalling a message.
+	sig := newTestSignal("org.example", "Changed", "av", []interface{}{1})
+	if _, err := sig._Marshal(); err == nil {
+		t.Error("no error marshalling a message with an invalid variant")
+	}
+}
+
+func TestPutValueArrays(t *testing.T) {
+	tests := []struct {
+		sig     string
+		val     interface{}
+		generic []interface{}
+	}{
+		{"ab", []bool{true, false}, []interface{}{true, false}},
+		{"ad", []float64{1.5, -2}, []interface{}{1.5, -2.0}},
+		{"ax", []int64{-1, 1 << 40}, []interface{}{int64(-1), int64(1 << 40)}},
+		{"a{su}", map[string]uint32{"a": 1}, []interface{}{[]interface{}{"a", uint32(1)}}},
+		{"a{sv}", map[string]interface{}{"k": []string{"v"}}, []interface{}{[]interface{}{"k", []interface{}{"v"}}}},
+	}
+	for _, test := range tests {
+		// Start unaligned, to check element alignment.
+		msg := &msgData{ByteOrder: binary.LittleEndian, Data: []byte{1}, Idx: 1}
+		if err := msg.putValue(mustParseSig(test.sig), reflect.ValueOf(test.val)); err != nil {
+			t.Errorf("%s: %s", test.sig, err)
+			continue
+		}
+		want := &msgData{ByteOrder: binary.LittleEndian, Data: []byte{1}, Idx: 1}
+		appendValue(want, mustParseSig(test.sig), test.generic)
+		if !bytes.Equal(msg.Data, want.Data) {
+			t.Errorf("%s: got %q, want %q", test.sig, msg.Data, want.Data)
+		}
+		ret, _, err := Parse(msg.Data, "y"+test.sig, 0)
+		if err != nil {
+			t.Errorf("%s: %s", test.sig, err)
+			continue
+		}
+		if !reflect.DeepEqual(ret[1], test.generic) {
+			t.Errorf("%s: decoded %#v, want %#v", test.sig, ret[1], test.generic)
+		}
+	}
+}
+
+// Values of these signatures, in the representation returned by Parse,
+// decode to equal values and marshal again to the same bytes.
+var deepNestingTests = []struct {
+	sig string
+	val interface{}
+}{
+	{"a{sa{sv}}", []interface{}{
+		[]interface{}{"org.a", []interface{}{
+			[]interface{}{"x", "s"},
+			[]interface{}{"n", uint32(1)},
+		}},
+		[]interface{}{"org.b", []interface{}{}},
+	}},
+	{"aa{sv}", []interface{}{
+		[]interface{}{[]interface{}{"a", int32(-1)}},
+		[]interface{}{},
+		[]interface{}{[]interface{}{"b", true}, []interface{}{"c", 2.5}},
+	}},
+	{"(sa{sv}as)", []interface{}{
+		"x",
+		[]interface{}{[]interface{}{"k", []interface{}{"l", "m"}}},
+		[]interface{}{"p", "q"},
+	}},
+	{"av", []interface{}{"s", uint32(2), []interface{}{"a"}, int64(-3), 1.5, byte(7)}},
+}
+
+func TestDeepNesting(t *testing.T) {
+	for _, test := range deepNestingTests {
+		msg := &msgData{ByteOrder: binary.LittleEndian}
+		if err := appendValue(msg, mustParseSig(test.sig), test.val); err != nil {
+			t.Errorf("%s: %s", test.sig, err)
+			continue
+		}
+		ret, idx, err := Parse(msg.Data, test.sig, 0)
+		if err != nil {
+			t.Errorf("%s: %s", test.sig, err)
+			continue
+		}
+		if idx != len(msg.Data) {
+			t.Errorf("%s: consumed %d bytes out of %d", test.sig, idx, len(msg.Data))
+		}
+		if !reflect.DeepEqual(ret, []interface{}{test.val}) {
+			t.Errorf("%s: got %#v, want %#v", test.sig, ret[0], test.val)
+		}
+		again := &msgData{ByteOrder: binary.LittleEndian}
+		if err := appendValue(again, mustParseSig(test.sig), ret[0]); err != nil {
+			t.Errorf("%s: %s", test.sig, err)
+			continue
+		}
+		if !bytes.Equal(again.Data, msg.Data) {
+			t.Errorf("%s: got\n%q\nwant\n%q", test.sig, again.Data, msg.Data)
+		}
+	}
+}
+
+func TestObjectPathRoundTrip(t *testing.T) {
+	val := []interface{}{ObjectPath("/org/example"), "/not/a/path"}
+	msg := &msgData{ByteOrder: binary.LittleEndian}
+	if err := appendValue(msg, mustParseSig("(ov)"), val); err != nil {
+		t.Fatal(err)
+	}
+	ret, _, err := Parse(msg.Data, "(ov)", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ret, []interface{}{val}) {
+		t.Errorf("got %#v, want %#v", ret[0], val)
+	}
+
+	// An object path in a variant keeps its type.
+	vals := []interface{}{ObjectPath("/org/example")}
+	msg = &msgData{ByteOrder: binary.LittleEndian}
+	if err := appendValue(msg, mustParseSig("av"), vals); err != nil {
+		t.Fatal(err)
+	}
+	if sig := string(msg.Data[4:6]); sig != "\x01o" {
+		t.Errorf("variant has signature %q, want o", sig)
+	}
+	ret, _, err = Parse(msg.Data, "av", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := &msgData{ByteOrder: binary.LittleEndian}
+	if err := appendValue(again, mustParseSig("av"), ret[0]); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Data, msg.Data) {
+		t.Errorf("got\n%q\nwant\n%q", again.Data, msg.Data)
+	}
+}
+
+func TestAsObjectPaths(t *testing.T) {
+	ret, _, err := Parse([]byte("\x1c\x00\x00\x00\x04\x00\x00\x00/a/b\x00\x00\x00\x00\x0b\x00\x00\x00/org/device\x00"), "ao", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := AsObjectPaths(ret[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(paths, []string{"/a/b", "/org/device"}) {
+		t.Errorf("got %q", paths)
+	}
+	if _, err = AsObjectPaths([]interface{}{uint32(1)}); err == nil {
+		t.Error("expected an error for a non-string element")
+	}
+}
+
+func TestAsStrings(t *testing.T) {
+	ret, _, err := Parse([]byte("\x14\x00\x00\x00\x05\x00\x00\x00:1.42\x00\x00\x00\x03\x00\x00\x00org\x00"), "as", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strs, err := AsStrings(ret[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(strs, []string{":1.42", "org"}) {
+		t.Errorf("got %q", strs)
+	}
+	if _, err = AsStrings([]interface{}{ObjectPath("/a")}); err == nil {
+		t.Error("expected an error for an object path")
+	}
+}
+
+func TestParseShortBody(t *testing.T) {
+	_, _, err := Parse([]byte("\x05\x00\x00\x00abc"), "s", 0)
+	if _, ok := err.(errShortBody); !ok {
+		t.Errorf("got error %v, want errShortBody", err)
+	}
+	_, _, err = Parse([]byte("\x01\x00"), "yu", 0)
+	if _, ok := err.(errShortBody); !ok {
+		t.Errorf("got error %v, want errShortBody", err)
+	}
+}
+
+func TestParseTrailingPadding(t *testing.T) {
+	ret, idx, err := Parse([]byte("\x04\x00\x00\x00\x00\x00\x00\x00"), "u", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ret, []interface{}{uint32(4)}) {
+		t.Errorf("got %v", ret)
+	}
+	if idx != 4 {
+		t.Errorf("consumed %d bytes, want 4", idx)
+	}
+}
+
 func TestGetVariant(t *testing.T) {
 	val, index, _ := _GetVariant([]byte("\x00\x00\x01s\x00\x00\x00\x00\x04\x00\x00\x00test\x00"), 2)
 	str, ok := val[0].(string)
@@ -162,6 +493,55 @@ func TestParseVariant(t *testing.T) {
 	}
 }
 
+func TestParseVariantScalars(t *testing.T) {
+	const data = "\x01n\x00\x00\xfe\xff" +
+		"\x01d\x00\x00\x00\x00\x00\x00\x00\x00" +
+		"\x00\x00\x00\x00\x00\x00\xf8\x3f"
+	vec, _, err := Parse([]byte(data), "vv", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := vec[0].(int16); !ok || v != -2 {
+		t.Errorf("got %#v, want int16(-2)", vec[0])
+	}
+	if v, ok := vec[1].(float64); !ok || v != 1.5 {
+		t.Errorf("got %#v, want 1.5", vec[1])
+	}
+
+	// Encoding infers the same signatures.
+	msg := &msgData{ByteOrder: binary.LittleEndian}
+	for _, v := range vec {
+		if err := appendValue(msg, mustParseSig("v"), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if string(msg.Data) != data {
+		t.Errorf("got %q, want %q", msg.Data, data)
+	}
+}
+
+func TestParseVariantSingleValue(t *testing.T) {
+	// A variant holding a struct, then a string.
+	const data = "\x04(us)\x00\x00\x00" +
+		"\x01\x00\x00\x00\x01\x00\x00\x00x\x00" +
+		"\x00\x00\x01\x00\x00\x00y\x00"
+	ret, _, err := Parse([]byte(data), "vs", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ret) != 2 {
+		t.Fatalf("got %d values, want 2: %#v", len(ret), ret)
+	}
+	want := []interface{}{[]interface{}{uint32(1), "x"}, "y"}
+	if !reflect.DeepEqual(ret, want) {
+		t.Errorf("got %#v, want %#v", ret, want)
+	}
+
+	if _, _, err = Parse([]byte("\x02yy\x00\x01\x02"), "v", 0); err == nil {
+		t.Error("expected an error for a variant of two values")
+	}
+}
+
 func TestParseNumber(t *testing.T) {
 	vec, _, e := Parse([]byte("\x04\x00\x00\x00"), "u", 0)
 	if nil != e {
@@ -192,6 +572,82 @@ func TestReflect(t *testing.T) {
 	t.Logf("%+v", flds)
 }
 
+func TestScanHeaderFieldSignature(t *testing.T) {
+	tests := []struct {
+		data string
+		err  errHeaderFieldSig
+	}{
+		// REPLY_SERIAL sent as a string.
+		{"l\x02\x00\x01\x00\x00\x00\x00\x01\x00\x00\x00\x0c\x00\x00\x00" +
+			"\x05\x01s\x00\x03\x00\x00\x00abc\x00\x00\x00\x00\x00",
+			errHeaderFieldSig{Field: 5, Sig: "s"}},
+		// PATH sent as a string.
+		{"l\x01\x00\x01\x00\x00\x00\x00\x01\x00\x00\x00\x0c\x00\x00\x00" +
+			"\x01\x01s\x00\x03\x00\x00\x00/ab\x00\x00\x00\x00\x00",
+			errHeaderFieldSig{Field: 1, Sig: "s"}},
+		// SIGNATURE sent as an uint32.
+		{"l\x01\x00\x01\x00\x00\x00\x00\x01\x00\x00\x00\x08\x00\x00\x00" +
+			"\x08\x01u\x00\x01\x00\x00\x00",
+			errHeaderFieldSig{Field: 8, Sig: "u"}},
+	}
+	for i, test := range tests {
+		msg := &msgData{ByteOrder: binary.LittleEndian, Data: []byte(test.data)}
+		_, _, err := msg.scanHeader()
+		if err != test.err {
+			t.Errorf("#%d: got error %v, want %v", i, err, test.err)
+		}
+	}
+	const want = `header field REPLY_SERIAL (5) has signature "s", expected "u"`
+	if s := tests[0].err.Error(); s != want {
+		t.Errorf("got %q, want %q", s, want)
+	}
+}
+
+func TestScanHeaderFieldBounds(t *testing.T) {
+	// MEMBER declares a 12 byte string in a 12 byte field array.
+	data := "l\x01\x00\x01\x00\x00\x00\x00\x01\x00\x00\x00\x0c\x00\x00\x00" +
+		"\x03\x01s\x00\x0c\x00\x00\x00Helloabcdefg\x00\x00\x00\x00"
+	msg := &msgData{ByteOrder: binary.LittleEndian, Data: []byte(data)}
+	_, _, err := msg.scanHeader()
+	want := errHeaderFieldBounds{Field: 3, End: 37, Limit: 28}
+	if err != want {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+}
+
+func TestScanHeaderUnknownField(t *testing.T) {
+	// Field 10 holding a string, followed by MEMBER.
+	data := "l\x01\x00\x01\x00\x00\x00\x00\x01\x00\x00\x00\x1e\x00\x00\x00" +
+		"\x0a\x01s\x00\x03\x00\x00\x00abc\x00\x00\x00\x00\x00" +
+		"\x03\x01s\x00\x05\x00\x00\x00Hello\x00\x00\x00"
+	msg := &msgData{ByteOrder: binary.LittleEndian, Data: []byte(data)}
+	_, flds, err := msg.scanHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flds.Member != "Hello" {
+		t.Errorf("got member %q, want Hello", flds.Member)
+	}
+
+	msg = &msgData{ByteOrder: binary.LittleEndian, Data: []byte(data), StrictHeader: true}
+	if _, _, err = msg.scanHeader(); err == nil {
+		t.Error("expected an error in strict mode")
+	}
+}
+
+func FuzzScanHeader(f *testing.F) {
+	f.Add([]byte(testMsg2))
+	f.Add([]byte(helloFrame))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg := &msgData{ByteOrder: binary.LittleEndian, Data: data}
+		if len(data) > 0 && data[0] == 'B' {
+			msg.ByteOrder = binary.BigEndian
+		}
+		// scanHeader must return errors instead of panicking.
+		msg.scanHeader()
+	})
+}
+
 type sigTest struct {
 	s   string
 	sig signature
@@ -206,9 +662,18 @@ var sigTests = []sigTest{
 	{"ai", arraySig{Elem: isig}},
 	{"a(ii)", arraySig{Elem: structSig{isig, isig}}},
 	{"aai", arraySig{Elem: arraySig{Elem: isig}}},
+	{"a{si}", dictSig{Key: 's', Value: isig}},
+	{"(sa{ss})", structSig{basicSig('s'), dictSig{Key: 's', Value: basicSig('s')}}},
 	// Incomplete
 	{"aa", nil},
 	{"(ii", nil},
+	{"a{si", nil},
+	// Invalid dict keys
+	{"a{(i)i}", nil},
+	{"a{vi}", nil},
+	// Empty structures
+	{"()", nil},
+	{"a()", nil},
 }
 
 func TestParseOneSig(t *testing.T) {
@@ -227,6 +692,35 @@ func TestParseOneSig(t *testing.T) {
 	}
 }
 
+func TestMarshalEmptyStruct(t *testing.T) {
+	msg := &msgData{ByteOrder: binary.LittleEndian}
+	if err := appendValue(msg, structSig{}, []interface{}{}); err != errEmptyStruct {
+		t.Errorf("appendValue: got error %v, want %v", err, errEmptyStruct)
+	}
+	if err := msg.putValue(structSig{}, reflect.ValueOf(struct{}{})); err != errEmptyStruct {
+		t.Errorf("putValue: got error %v, want %v", err, errEmptyStruct)
+	}
+	if err := msg.put("()", struct{}{}); err == nil {
+		t.Error("put: expected an error")
+	}
+	if len(msg.Data) != 0 {
+		t.Errorf("empty structure wrote %x", msg.Data)
+	}
+}
+
+func TestPutFdIndex(t *testing.T) {
+	msg := &msgData{ByteOrder: binary.LittleEndian}
+	if err := msg.put("(yh)", struct {
+		B  byte
+		Fd uint32
+	}{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "\x01\x00\x00\x00\x02\x00\x00\x00"; string(msg.Data) != want {
+		t.Errorf("got %q, want %q", msg.Data, want)
+	}
+}
+
 func TestSignatureRoundTrip(t *testing.T) {
 	for _, test := range sigTests {
 		if test.sig == nil {
@@ -238,3 +732,311 @@ func TestSignatureRoundTrip(t *testing.T) {
 		}
 	}
 }
+
+type propsStruct struct {
+	Iface string
+	Props map[string]string
+}
+
+func TestScanStructWithDict(t *testing.T) {
+	const data = "\x05\x00\x00\x00org.x\x00\x00\x00" + // s
+		"\x1f\x00\x00\x00" + // array length
+		"\x01\x00\x00\x00k\x00\x00\x00\x01\x00\x00\x00v\x00\x00\x00" +
+		"\x01\x00\x00\x00a\x00\x00\x00\x02\x00\x00\x00bc\x00"
+	msg := &msgData{ByteOrder: binary.LittleEndian, Data: []byte(data)}
+	var v propsStruct
+	if err := msg.scan("(sa{ss})", &v); err != nil {
+		t.Fatal(err)
+	}
+	want := propsStruct{"org.x", map[string]string{"k": "v", "a": "bc"}}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("got %+v, want %+v", v, want)
+	}
+	if msg.Idx != len(data) {
+		t.Errorf("consumed %d bytes, want %d", msg.Idx, len(data))
+	}
+}
+
+func TestStrictStrings(t *testing.T) {
+	tests := []struct {
+		sig, data string
+	}{
+		{"s", "\x03\x00\x00\x00a\xffb\x00"},
+		{"s", "\x03\x00\x00\x00a\x00b\x00"},
+		{"o", "\x04\x00\x00\x00/a//\x00"},
+		{"o", "\x04\x00\x00\x00/a-b\x00"},
+		{"g", "\x02a{\x00"},
+	}
+	for _, test := range tests {
+		msg := &msgData{ByteOrder: binary.LittleEndian, Data: []byte(test.data)}
+		if _, err := msg.parse(test.sig); err != nil {
+			t.Errorf("%s %q: lenient decoding failed: %s", test.sig, test.data, err)
+		}
+		msg = &msgData{ByteOrder: binary.LittleEndian, Data: []byte(test.data), StrictStrings: true}
+		_, err := msg.parse(test.sig)
+		if _, ok := err.(errInvalidString); !ok {
+			t.Errorf("%s %q: got error %v, want errInvalidString", test.sig, test.data, err)
+		}
+		var s string
+		msg = &msgData{ByteOrder: binary.LittleEndian, Data: []byte(test.data), StrictStrings: true}
+		err = msg.scan(test.sig, &s)
+		if _, ok := err.(errInvalidString); !ok {
+			t.Errorf("%s %q: scan: got error %v, want errInvalidString", test.sig, test.data, err)
+		}
+	}
+
+	for _, path := range []string{"/", "/org", "/org/freedesktop_1/DBus"} {
+		if !validObjectPath(path) {
+			t.Errorf("%q is a valid object path", path)
+		}
+	}
+	for _, path := range []string{"", "org", "/org/", "//", "/a b"} {
+		if validObjectPath(path) {
+			t.Errorf("%q is not a valid object path", path)
+		}
+	}
+}
+
+func TestScanStructFieldCount(t *testing.T) {
+	const data = "\x01\x00\x00\x00\x02\x00\x00\x00"
+	var one struct{ A int32 }
+	msg := &msgData{ByteOrder: binary.LittleEndian, Data: []byte(data)}
+	err := msg.scan("(ii)", &one)
+	if err != (errStructFields{Type: reflect.TypeOf(one), Values: 2}) {
+		t.Errorf("one field: got error %v, want errStructFields", err)
+	}
+	var three struct{ A, B, C int32 }
+	msg = &msgData{ByteOrder: binary.LittleEndian, Data: []byte(data)}
+	err = msg.scan("(ii)", &three)
+	if err != (errStructFields{Type: reflect.TypeOf(three), Values: 2}) {
+		t.Errorf("three fields: got error %v, want errStructFields", err)
+	}
+	const want = "struct struct { A int32; B int32; C int32 } has 3 fields, D-Bus structure has 2"
+	if err != nil && err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+
+	msg = &msgData{ByteOrder: binary.LittleEndian}
+	err = msg.putValue(mustParseSig("(ii)"), reflect.ValueOf(one))
+	if _, ok := err.(errStructFields); !ok {
+		t.Errorf("putValue: got error %v, want errStructFields", err)
+	}
+}
+
+func TestScanSignature(t *testing.T) {
+	msg := &msgData{ByteOrder: binary.LittleEndian, Data: []byte("\x0ba{sv}(ii)as\x00")}
+	var sig Signature
+	if err := msg.scan("g", &sig); err != nil {
+		t.Fatal(err)
+	}
+	elems, err := sig.Elements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Signature{"a{sv}", "(ii)", "as"}
+	if !reflect.DeepEqual(elems, want) {
+		t.Errorf("got %q, want %q", elems, want)
+	}
+
+	msg = &msgData{ByteOrder: binary.LittleEndian, Data: []byte("\x03(ii\x00")}
+	if err := msg.scan("g", &sig); err == nil {
+		t.Error("expected an error for an invalid signature")
+	}
+}
+
+func TestScanPointer(t *testing.T) {
+	msg := &msgData{ByteOrder: binary.LittleEndian, Data: []byte("\x04\x00\x00\x00test\x00")}
+	var s *string
+	if err := msg.scan("s", &s); err != nil {
+		t.Fatal(err)
+	}
+	if s == nil || *s != "test" {
+		t.Errorf("got %v, want pointer to %q", s, "test")
+	}
+
+	var v struct {
+		Id   uint32
+		Name *string
+	}
+	msg = &msgData{ByteOrder: binary.LittleEndian, Data: []byte("\x07\x00\x00\x00\x04\x00\x00\x00test\x00")}
+	if err := msg.scan("(us)", &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Id != 7 || v.Name == nil || *v.Name != "test" {
+		t.Errorf("got %+v", v)
+	}
+}
+
+func TestScanArrayElems(t *testing.T) {
+	const data = "\x0f\x00\x00\x00\x01\x00\x00\x00a\x00\x00\x00\x02\x00\x00\x00bc\x00"
+	want := []string{"a", "bc"}
+
+	var strs []string
+	msg := &msgData{ByteOrder: binary.LittleEndian, Data: []byte(data)}
+	if err := msg.scan("as", &strs); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(strs, want) {
+		t.Errorf("got %q, want %q", strs, want)
+	}
+
+	var ifaces []interface{}
+	msg = &msgData{ByteOrder: binary.LittleEndian, Data: []byte(data)}
+	if err := msg.scan("as", &ifaces); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ifaces, []interface{}{"a", "bc"}) {
+		t.Errorf("got %#v, want %q", ifaces, want)
+	}
+
+	var ptrs []*string
+	msg = &msgData{ByteOrder: binary.LittleEndian, Data: []byte(data)}
+	if err := msg.scan("as", &ptrs); err != nil {
+		t.Fatal(err)
+	}
+	if len(ptrs) != 2 || *ptrs[0] != "a" || *ptrs[1] != "bc" {
+		t.Errorf("got %v, want pointers to %q", ptrs, want)
+	}
+}
+
+func TestScanStructTags(t *testing.T) {
+	const data = "\x01\x00\x00\x00a\x00\x00\x00\x07\x00\x00\x00\x01\x00\x00\x00b\x00"
+	var skip struct {
+		First string
+		Id    uint32 `dbus:"-"`
+		Last  string
+	}
+	msg := &msgData{ByteOrder: binary.LittleEndian, Data: []byte(data)}
+	if err := msg.scan("(sus)", &skip); err != nil {
+		t.Fatal(err)
+	}
+	if skip.First != "a" || skip.Id != 0 || skip.Last != "b" {
+		t.Errorf("got %+v", skip)
+	}
+
+	var reorder struct {
+		Id   uint32 `dbus:"1"`
+		Last string `dbus:"2"`
+	}
+	msg = &msgData{ByteOrder: binary.LittleEndian, Data: []byte(data)}
+	if err := msg.scan("(sus)", &reorder); err != nil {
+		t.Fatal(err)
+	}
+	if reorder.Id != 7 || reorder.Last != "b" {
+		t.Errorf("got %+v", reorder)
+	}
+	if msg.Idx != len(data) {
+		t.Errorf("consumed %d bytes, want %d", msg.Idx, len(data))
+	}
+
+	var dup struct {
+		A string
+		B string `dbus:"0"`
+	}
+	msg = &msgData{ByteOrder: binary.LittleEndian, Data: []byte(data)}
+	if err := msg.scan("(sus)", &dup); err == nil {
+		t.Error("expected an error for duplicate positions")
+	}
+}
+
+func TestScanArrayOfStructs(t *testing.T) {
+	// a(su): each element is aligned on 8 bytes.
+	const asu = "\x2c\x00\x00\x00\x00\x00\x00\x00" +
+		"\x01\x00\x00\x00a\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00" +
+		"\x03\x00\x00\x00bcd\x00\x02\x00\x00\x00\x00\x00\x00\x00" +
+		"\x01\x00\x00\x00e\x00\x00\x00\x03\x00\x00\x00"
+	var got []struct {
+		Name string
+		Id   uint32
+	}
+	msg := &msgData{ByteOrder: binary.LittleEndian, Data: []byte(asu)}
+	if err := msg.scan("a(su)", &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		Name string
+		Id   uint32
+	}{{"a", 1}, {"bcd", 2}, {"e", 3}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if msg.Idx != len(asu) {
+		t.Errorf("consumed %d bytes, want %d", msg.Idx, len(asu))
+	}
+}
+
+func TestScanStructPadding(t *testing.T) {
+	// (yt): 7 bytes of padding between the byte and the uint64.
+	const yt = "\x2a\x00\x00\x00\x00\x00\x00\x00\x01\x02\x03\x04\x05\x06\x07\x08"
+	var s1 struct {
+		A byte
+		B uint64
+	}
+	msg := &msgData{ByteOrder: binary.LittleEndian, Data: []byte(yt)}
+	if err := msg.scan("(yt)", &s1); err != nil {
+		t.Fatal(err)
+	}
+	if s1.A != 42 || s1.B != 0x0807060504030201 {
+		t.Errorf("got %+v", s1)
+	}
+
+	// (y(nd)): the inner struct is aligned on 8 bytes, and the double
+	// is padded after the int16.
+	const ynd = "\x01\x00\x00\x00\x00\x00\x00\x00" +
+		"\xfe\xff\x00\x00\x00\x00\x00\x00" +
+		"\x00\x00\x00\x00\x00\x00\xf8\x3f"
+	var s2 struct {
+		A byte
+		B struct {
+			N int16
+			D float64
+		}
+	}
+	msg = &msgData{ByteOrder: binary.LittleEndian, Data: []byte(ynd)}
+	if err := msg.scan("(y(nd))", &s2); err != nil {
+		t.Fatal(err)
+	}
+	if s2.A != 1 || s2.B.N != -2 || s2.B.D != 1.5 {
+		t.Errorf("got %+v", s2)
+	}
+	if msg.Idx != len(ynd) {
+		t.Errorf("consumed %d bytes, want %d", msg.Idx, len(ynd))
+	}
+}
+
+// Decoding bodies of basic types, with and without the fast path:
+//
+//	BenchmarkParseBasic/yu/fast        48 B/op    3 allocs/op
+//	BenchmarkParseBasic/yu/generic     80 B/op    4 allocs/op
+//	BenchmarkParseBasic/ysy/fast       84 B/op    4 allocs/op
+//	BenchmarkParseBasic/ysy/generic   148 B/op    5 allocs/op
+func BenchmarkParseBasic(b *testing.B) {
+	bodies := []struct{ sig, data string }{
+		{"yu", "l\x00\x00\x00\x00\x01\x00\x00"},
+		{"ysy", "\x03\x00\x00\x00\x04\x00\x00\x00test\x00\x04"},
+	}
+	for _, body := range bodies {
+		b.Run(body.sig+"/fast", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				msg := &msgData{ByteOrder: binary.LittleEndian, Data: []byte(body.data)}
+				if _, err := msg.parse(body.sig); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(body.sig+"/generic", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				msg := &msgData{ByteOrder: binary.LittleEndian, Data: []byte(body.data)}
+				sigs, err := parseSignature(body.sig)
+				if err == nil {
+					_, err = parseVariants(msg, sigs)
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,27 +1,36 @@
 package dbus
 
-import "reflect"
 import "fmt"
 import "strings"
 
 // Matches all messages with equal type, interface, member, or path.
 // Any missing/invalid fields are not matched against.
+// PathNamespace matches messages whose path is equal to it or one of
+// its descendants.
 type MatchRule struct {
-	Type      MessageType
-	Interface string
-	Member    string
-	Path      string
+	Type          MessageType
+	Interface     string
+	Member        string
+	Path          string
+	PathNamespace string
 }
 
 // A string representation af the MatchRule (D-Bus variant map).
 func (p *MatchRule) String() string {
 	strslice := []string{}
+	add := func(key, value string) {
+		if value != "" {
+			strslice = append(strslice, fmt.Sprintf("%s='%s'", key, value))
+		}
+	}
 
-	v := reflect.Indirect(reflect.ValueOf(p))
-	t := v.Type()
-	for i := 0; i < v.NumField(); i++ {
-		strslice = append(strslice, (fmt.Sprintf("%s='%v'", strings.ToLower(t.Field(i).Name), v.Field(i).Interface())))
+	if p.Type != TypeInvalid {
+		add("type", p.Type.String())
 	}
+	add("interface", p.Interface)
+	add("member", p.Member)
+	add("path", p.Path)
+	add("path_namespace", p.PathNamespace)
 
 	return strings.Join(strslice, ",")
 }
@@ -39,5 +48,16 @@ func (p *MatchRule) _Match(msg *Message) bool {
 	if p.Path != "" && p.Path != msg.Path {
 		return false
 	}
+	if p.PathNamespace != "" && !inPathNamespace(msg.Path, p.PathNamespace) {
+		return false
+	}
 	return true
 }
+
+// inPathNamespace reports whether path is ns or one of its descendants.
+func inPathNamespace(path, ns string) bool {
+	if ns == "/" {
+		return strings.HasPrefix(path, "/")
+	}
+	return path == ns || strings.HasPrefix(path, ns+"/")
+}
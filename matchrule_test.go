@@ -17,3 +17,33 @@ func TestToString(t *testing.T) {
 		t.Error("#1 Failed")
 	}
 }
+
+func TestPathNamespaceString(t *testing.T) {
+	verifyStr := "type='signal',path_namespace='/org/bluez'"
+	mr := MatchRule{Type: TypeSignal, PathNamespace: "/org/bluez"}
+	if mr.String() != verifyStr {
+		t.Errorf("got %q, want %q", mr.String(), verifyStr)
+	}
+}
+
+func TestPathNamespaceMatch(t *testing.T) {
+	tests := []struct {
+		ns, path string
+		match    bool
+	}{
+		{"/a/b", "/a/b", true},
+		{"/a/b", "/a/b/c", true},
+		{"/a/b", "/a/b/c/d", true},
+		{"/a/b", "/a/bc", false},
+		{"/a/b", "/a", false},
+		{"/a/b", "/x/a/b", false},
+		{"/", "/a/b", true},
+	}
+	for _, test := range tests {
+		mr := MatchRule{Type: TypeSignal, PathNamespace: test.ns}
+		msg := &Message{Type: TypeSignal, Path: test.path}
+		if got := mr._Match(msg); got != test.match {
+			t.Errorf("namespace %q, path %q: got %v, want %v", test.ns, test.path, got, test.match)
+		}
+	}
+}
@@ -1,13 +1,19 @@
 package dbus
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
 	"reflect"
 	"sync/atomic"
 )
 
 // See the D-Bus tutorial for information about message types.
-//		http://dbus.freedesktop.org/doc/dbus-tutorial.html#messages
+//
+//	http://dbus.freedesktop.org/doc/dbus-tutorial.html#messages
 type MessageType uint8
 
 const (
@@ -48,12 +54,21 @@ type Message struct {
 	serial      uint32
 	replySerial uint32
 	ErrorName   string
-	//	Sender;
+	Sender      string
 
 	byteOrder binary.ByteOrder // Raw data byte order.
 	raw       []byte           // Raw data.
+	frame     []byte           // Whole message as received, if kept.
 	Params    []interface{}    // Unmarshaled contents.
 	reflect   bool             // Whether Params must be reflected.
+	rawBody   bool             // Whether raw is sent as is.
+	strict    bool             // Whether strings are validated when decoding.
+
+	// File descriptors received with the message, referenced
+	// by index from values of type 'h'.
+	Fds    []int
+	numFds uint32     // Number of file descriptors declared in the header.
+	files  []*os.File // Fds as files, shared by all decoded values.
 }
 
 var messageSerial = uint32(0)
@@ -76,14 +91,27 @@ func NewMessage() *Message {
 	return msg
 }
 
+// NewCall creates a method call message to member of interface iface
+// of the object at path, owned by dest.
+func NewCall(dest, path, iface, member string) *Message {
+	msg := NewMessage()
+	msg.Type = TypeMethodCall
+	msg.Dest = dest
+	msg.Path = path
+	msg.Iface = iface
+	msg.Member = member
+	return msg
+}
+
 func newRawMessage(data []byte) (*Message, error) {
-	msg := &msgData{Data: data, Idx: 0}
-	switch data[0] {
-	case 'l':
-		msg.ByteOrder = binary.LittleEndian
-	case 'B':
-		msg.ByteOrder = binary.BigEndian
-	}
+	return parseRawMessage(data, false)
+}
+
+// parseRawMessage decodes the header of a message. With strict,
+// header fields unknown to the specification are rejected. The body
+// of the message aliases data, see Retain.
+func parseRawMessage(data []byte, strict bool) (*Message, error) {
+	msg := newHeaderData(data, strict)
 	hdr, flds, err := msg.scanHeader()
 	if err != nil {
 		return nil, err
@@ -103,27 +131,119 @@ func newRawMessage(data []byte) (*Message, error) {
 		ErrorName:   flds.ErrorName,
 		replySerial: flds.ReplySerial,
 		Dest:        flds.Destination,
-		// FIXME:  flds.Sender
-		Sig: string(flds.Signature),
-		// FIXME:  flds.NumFDs
+		Sender:      flds.Sender,
+		Sig:         string(flds.Signature),
+		numFds:      flds.NumFD,
 	}
 
 	msg.Round(8)
+	if msg.Idx > len(data) || len(data)-msg.Idx != p.bodyLength {
+		return nil, errBodyLength{Declared: p.bodyLength, Actual: len(data) - msg.Idx}
+	}
 	p.raw = data[msg.Idx:]
 	return p, nil
 }
 
+// newHeaderData prepares the decoding of the header of data, in the
+// byte order it declares.
+func newHeaderData(data []byte, strict bool) *msgData {
+	msg := &msgData{Data: data, Idx: 0, StrictHeader: strict}
+	switch data[0] {
+	case 'l':
+		msg.ByteOrder = binary.LittleEndian
+	case 'B':
+		msg.ByteOrder = binary.BigEndian
+	}
+	return msg
+}
+
+// rawNumFds returns the number of file descriptors declared by the
+// header of a message which parseRawMessage rejected, or 0 if its
+// header fields cannot be read.
+func rawNumFds(data []byte) uint32 {
+	_, flds, err := newHeaderData(data, false).scanHeader()
+	if err != nil {
+		return 0
+	}
+	return flds.NumFD
+}
+
+type errBodyLength struct{ Declared, Actual int }
+
+func (e errBodyLength) Error() string {
+	return fmt.Sprintf("message body length is %d bytes, header declares %d", e.Actual, e.Declared)
+}
+
+type errEmptyBody struct{ Sig string }
+
+func (e errEmptyBody) Error() string {
+	return fmt.Sprintf("message body is empty but signature is %q", e.Sig)
+}
+
+type errNumFds struct{ Declared, Received int }
+
+func (e errNumFds) Error() string {
+	return fmt.Sprintf("message header declares %d file descriptors, %d received", e.Declared, e.Received)
+}
+
+type errTrailingBody struct {
+	Sig   string
+	Extra int
+}
+
+func (e errTrailingBody) Error() string {
+	return fmt.Sprintf("message body has %d bytes after the values of signature %q", e.Extra, e.Sig)
+}
+
+// isPadding reports whether b can be alignment padding.
+func isPadding(b []byte) bool {
+	if len(b) >= 8 {
+		return false
+	}
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 func (p *Message) parseParams() (err error) {
+	if p.bodyLength == 0 && p.Sig != "" {
+		return errEmptyBody{p.Sig}
+	}
+	// Values of type 'h' are indexes below UNIX_FDS, which may
+	// be repeated: they are checked when decoded.
+	if len(p.Fds) != int(p.numFds) {
+		return errNumFds{Declared: int(p.numFds), Received: len(p.Fds)}
+	}
+	msg := &msgData{ByteOrder: p.byteOrder, Data: p.raw, Files: p.fdFiles(), StrictStrings: p.strict}
 	if p.bodyLength > 0 {
-		p.Params, _, err = Parse(p.raw, p.Sig, 0)
+		p.Params, err = msg.parse(p.Sig)
+		if err == nil && !isPadding(p.raw[msg.Idx:]) {
+			err = errTrailingBody{Sig: p.Sig, Extra: len(p.raw) - msg.Idx}
+		}
 	}
 	return
 }
 
+// fdFiles returns the file descriptors of the message wrapped as
+// files. They are created once, so that decoding the message several
+// times yields the same files, closed only once.
+func (p *Message) fdFiles() []*os.File {
+	if p.files == nil && len(p.Fds) > 0 {
+		p.files = make([]*os.File, len(p.Fds))
+		for i, fd := range p.Fds {
+			p.files[i] = os.NewFile(uintptr(fd), "dbus-fd")
+		}
+	}
+	return p.files
+}
+
 // Unmarshal unmarshals the message payload in a reflective
 // manner.
 func (p *Message) Unmarshal(out ...interface{}) error {
-	msg := &msgData{ByteOrder: p.byteOrder, Data: p.raw, Idx: 0}
+	msg := &msgData{ByteOrder: p.byteOrder, Data: p.raw, Idx: 0, Files: p.fdFiles(), StrictStrings: p.strict}
 	outv := make([]reflect.Value, len(out))
 	for i := range outv {
 		outv[i] = reflect.ValueOf(out[i]).Elem()
@@ -131,6 +251,119 @@ func (p *Message) Unmarshal(out ...interface{}) error {
 	return msg.scanMany(p.Sig, outv...)
 }
 
+// unmarshalValue decodes the whole message payload into val. A payload
+// made of several values is decoded as a struct.
+func (p *Message) unmarshalValue(val reflect.Value) error {
+	msg := &msgData{ByteOrder: p.byteOrder, Data: p.raw, Idx: 0, Files: p.fdFiles(), StrictStrings: p.strict}
+	sigs, err := parseSignature(p.Sig)
+	if err != nil {
+		return errSignature{Sig: p.Sig, E: err}
+	}
+	sig := signature(structSig(sigs))
+	if len(sigs) == 1 {
+		sig = sigs[0]
+	}
+	return msg.scanValue(sig, val)
+}
+
+type errResultSig struct{ Sig, Want string }
+
+func (e errResultSig) Error() string {
+	return fmt.Sprintf("message has signature %q, expected %q", e.Sig, e.Want)
+}
+
+// result returns the single value of a message of signature sig.
+func (p *Message) result(sig string) (interface{}, error) {
+	if p.Sig != sig || len(p.Params) != 1 {
+		return nil, errResultSig{Sig: p.Sig, Want: sig}
+	}
+	return p.Params[0], nil
+}
+
+// StringResult returns the value of a message holding a single string.
+func (p *Message) StringResult() (string, error) {
+	v, err := p.result("s")
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("message value is %T, expected a string", v)
+	}
+	return s, nil
+}
+
+// Uint32Result returns the value of a message holding a single uint32.
+func (p *Message) Uint32Result() (uint32, error) {
+	v, err := p.result("u")
+	if err != nil {
+		return 0, err
+	}
+	u, ok := v.(uint32)
+	if !ok {
+		return 0, fmt.Errorf("message value is %T, expected a uint32", v)
+	}
+	return u, nil
+}
+
+// BoolResult returns the value of a message holding a single boolean.
+func (p *Message) BoolResult() (bool, error) {
+	v, err := p.result("b")
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("message value is %T, expected a bool", v)
+	}
+	return b, nil
+}
+
+// Body returns the raw message body, as received, in the byte order
+// of the message.
+func (p *Message) Body() []byte { return p.raw }
+
+// Raw returns the whole message, header and body, as it was received.
+// It is kept by UnmarshalAll, and by connections only after
+// SetKeepRaw. Otherwise Raw returns nil.
+func (p *Message) Raw() []byte { return p.frame }
+
+// Retain makes the message own its body. A decoded message shares its
+// body with the buffer it was decoded from, such as the input of
+// UnmarshalAll, so Body, Raw and Unmarshal see later writes to that
+// buffer. Params are copied when decoded. Retain copies the message so
+// that the buffer can be reused.
+func (p *Message) Retain() {
+	if p.frame != nil {
+		p.frame = append([]byte(nil), p.frame...)
+		p.raw = p.frame[len(p.frame)-len(p.raw):]
+	} else if p.raw != nil {
+		p.raw = append([]byte(nil), p.raw...)
+	}
+}
+
+// SetRawBody sets the message body to the already marshalled bytes
+// of body, which must be little-endian and match sig. It is sent
+// without being decoded or marshalled again, for example to forward
+// a received message.
+func (p *Message) SetRawBody(sig string, body []byte) {
+	p.Sig = sig
+	p.raw = body
+	p.bodyLength = len(body)
+	p.rawBody = true
+	p.Params = nil
+}
+
+type errArity struct {
+	Sig    string
+	Values int
+}
+
+func (e errArity) Error() string {
+	sigs, _ := parseSignature(e.Sig)
+	return fmt.Sprintf("signature %q has %d values, got %d arguments", e.Sig, len(sigs), e.Values)
+}
+
 func unmarshal(buff []byte) (*Message, error) {
 	msg, err := newRawMessage(buff)
 	if err != nil {
@@ -140,8 +373,83 @@ func unmarshal(buff []byte) (*Message, error) {
 	return msg, err
 }
 
-func (p *Message) _Marshal() ([]byte, error) {
-	b := make([]byte, 0, 8+len(p.Dest)+len(p.Path)+len(p.Iface)+len(p.Member))
+// UnmarshalAll decodes a sequence of concatenated messages, as read
+// from a connection or a capture. The bytes of each message are
+// available from its Raw method.
+func UnmarshalAll(buff []byte) ([]*Message, error) {
+	r := bufio.NewReader(bytes.NewReader(buff))
+	var msgs []*Message
+	for {
+		if _, err := r.Peek(1); err == io.EOF {
+			return msgs, nil
+		}
+		raw, _, err := popMessage(r)
+		if err == io.EOF {
+			// Less than a header left.
+			err = errIncompleteMessage{io.ErrUnexpectedEOF}
+		}
+		if err != nil {
+			return msgs, err
+		}
+		msg, err := unmarshal(raw)
+		if err != nil {
+			return msgs, err
+		}
+		msg.frame = raw
+		msgs = append(msgs, msg)
+	}
+}
+
+type errDecodeAt struct {
+	Offset int
+	E      error
+}
+
+func (e errDecodeAt) Error() string {
+	return fmt.Sprintf("message at offset %d: %s", e.Offset, e.E)
+}
+
+func (e errDecodeAt) Unwrap() error { return e.E }
+
+// DecodeAll is like UnmarshalAll but does not stop at the first
+// message which fails to decode: it is skipped, using the lengths in
+// its header, and the error is returned along with the offset of the
+// message. Decoding only stops when these lengths cannot be read.
+func DecodeAll(buff []byte) ([]*Message, []error) {
+	r := bufio.NewReader(bytes.NewReader(buff))
+	var msgs []*Message
+	var errs []error
+	offset := 0
+	for {
+		if _, err := r.Peek(1); err == io.EOF {
+			return msgs, errs
+		}
+		raw, _, err := popMessage(r)
+		if err == io.EOF {
+			// Less than a header left.
+			err = errIncompleteMessage{io.ErrUnexpectedEOF}
+		}
+		if err != nil {
+			return msgs, append(errs, errDecodeAt{Offset: offset, E: err})
+		}
+		msg, err := unmarshal(raw)
+		if err != nil {
+			errs = append(errs, errDecodeAt{Offset: offset, E: err})
+		} else {
+			msg.frame = raw
+			msgs = append(msgs, msg)
+		}
+		offset += len(raw)
+	}
+}
+
+// marshal marshals the message header and body separately. With
+// sizeOnly, only their sizes are computed.
+func (p *Message) marshal(sizeOnly bool) (header, body *msgData, err error) {
+	var b []byte
+	if !sizeOnly {
+		b = make([]byte, 0, 8+len(p.Dest)+len(p.Path)+len(p.Iface)+len(p.Member))
+	}
 	hdr := msgHeader{
 		ByteOrder: 'l',
 		Type:      byte(p.Type),
@@ -158,29 +466,35 @@ func (p *Message) _Marshal() ([]byte, error) {
 		ReplySerial: p.replySerial,
 		Destination: p.Dest,
 		Signature:   p.Sig,
-		// Sender, NumFDs
+		Sender:      p.Sender,
+		NumFD:       uint32(len(p.Fds)),
 	}
 
 	msg := &msgData{
 		ByteOrder: binary.LittleEndian,
-		Data:      b, Idx: 0}
-	err := msg.putHeader(hdr, flds)
+		Data:      b, Idx: 0, SizeOnly: sizeOnly}
+	err = msg.putHeader(hdr, flds)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Build serialized payload.
-	submsg := &msgData{ByteOrder: binary.LittleEndian}
+	submsg := &msgData{ByteOrder: binary.LittleEndian, SizeOnly: sizeOnly}
 	sigs, err := parseSignature(p.Sig)
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
-	if !p.reflect {
+	if !p.rawBody && len(p.Params) != len(sigs) {
+		return nil, nil, errArity{Sig: p.Sig, Values: len(p.Params)}
+	}
+	if p.rawBody {
+		submsg.Put(p.raw)
+	} else if !p.reflect {
 		// Unstructured representation.
 		for i, sigelem := range sigs {
-			err = appendValue(msg, sigelem, p.Params[i])
+			err = appendValue(submsg, sigelem, p.Params[i])
 			if err != nil {
-				panic(err)
+				return nil, nil, err
 			}
 		}
 	} else {
@@ -188,14 +502,31 @@ func (p *Message) _Marshal() ([]byte, error) {
 		for i, sigelem := range sigs {
 			err = submsg.putValue(sigelem, reflect.ValueOf(p.Params[i]))
 			if err != nil {
-				panic(err)
+				return nil, nil, err
 			}
 		}
 	}
+	return msg, submsg, nil
+}
 
-	msg.ByteOrder.PutUint32(msg.Data[4:8], uint32(len(submsg.Data)))
+func (p *Message) _Marshal() ([]byte, error) {
+	msg, body, err := p.marshal(false)
+	if err != nil {
+		return nil, err
+	}
+	msg.patchUint32(4, uint32(len(body.Data)))
 	msg.Round(8)
-	msg.Put(submsg.Data)
-
+	msg.Put(body.Data)
 	return msg.Data, nil
 }
+
+// MarshalledSize returns the size of the message on the wire,
+// without marshalling it.
+func (p *Message) MarshalledSize() (int, error) {
+	header, body, err := p.marshal(true)
+	if err != nil {
+		return 0, err
+	}
+	header.Round(8)
+	return header.Idx + body.Idx, nil
+}
@@ -1,12 +1,20 @@
 package dbus
 
-import "testing"
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+)
 
-func TestUnmarshal(t *testing.T) {
+// helloFrame is the Hello call sent by clients to the bus, with serial 1.
+const helloFrame = "l\x01\x00\x01\x00\x00\x00\x00\x01\x00\x00\x00m\x00\x00\x00\x01\x01o\x00\x15\x00\x00\x00/org/freedesktop/DBus\x00\x00\x00\x02\x01s\x00\x14\x00\x00\x00org.freedesktop.DBus\x00\x00\x00\x00\x03\x01s\x00\x05\x00\x00\x00Hello\x00\x00\x00\x06\x01s\x00\x14\x00\x00\x00org.freedesktop.DBus\x00\x00\x00\x00"
 
-	teststr := "l\x01\x00\x01\x00\x00\x00\x00\x01\x00\x00\x00m\x00\x00\x00\x01\x01o\x00\x15\x00\x00\x00/org/freedesktop/DBus\x00\x00\x00\x02\x01s\x00\x14\x00\x00\x00org.freedesktop.DBus\x00\x00\x00\x00\x03\x01s\x00\x05\x00\x00\x00Hello\x00\x00\x00\x06\x01s\x00\x14\x00\x00\x00org.freedesktop.DBus\x00\x00\x00\x00"
+func TestUnmarshal(t *testing.T) {
 
-	msg, e := unmarshal([]byte(teststr))
+	msg, e := unmarshal([]byte(helloFrame))
 	if nil != e {
 		t.Error("Unmarshal Failed")
 	}
@@ -27,9 +35,291 @@ func TestUnmarshal(t *testing.T) {
 	}
 }
 
-func TestMarshal(t *testing.T) {
-	teststr := "l\x01\x00\x01\x00\x00\x00\x00\x01\x00\x00\x00m\x00\x00\x00\x01\x01o\x00\x15\x00\x00\x00/org/freedesktop/DBus\x00\x00\x00\x02\x01s\x00\x14\x00\x00\x00org.freedesktop.DBus\x00\x00\x00\x00\x03\x01s\x00\x05\x00\x00\x00Hello\x00\x00\x00\x06\x01s\x00\x14\x00\x00\x00org.freedesktop.DBus\x00\x00\x00\x00"
+func TestUnmarshalAll(t *testing.T) {
+	msgs, err := UnmarshalAll([]byte(helloFrame + helloFrame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages, want 2", len(msgs))
+	}
+	for i, msg := range msgs {
+		if msg.Type != TypeMethodCall || msg.Member != "Hello" || len(msg.Body()) != 0 {
+			t.Errorf("message #%d: got %s %s with %d body bytes", i, msg.Type, msg.Member, len(msg.Body()))
+		}
+	}
+
+	// A truncated second message.
+	msgs, err = UnmarshalAll([]byte(helloFrame + helloFrame[:10]))
+	if err == nil || len(msgs) != 1 {
+		t.Errorf("got %d messages and error %v for truncated input", len(msgs), err)
+	}
+}
+
+func TestUnmarshalAllRaw(t *testing.T) {
+	sig := newTestSignal("org.example", "Changed", "su", "hello", uint32(42))
+	data, err := sig._Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buff := append([]byte(helloFrame), data...)
+
+	msgs, err := UnmarshalAll(buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var capture []byte
+	for _, msg := range msgs {
+		capture = append(capture, msg.Raw()...)
+	}
+	if !bytes.Equal(capture, buff) {
+		t.Errorf("got capture %q, want %q", capture, buff)
+	}
+	if !bytes.Equal(msgs[1].Raw(), data) {
+		t.Errorf("got %q, want %q", msgs[1].Raw(), data)
+	}
+}
+
+func TestDecodeAll(t *testing.T) {
+	// A signal with an invalid header field ID.
+	const bad = "l\x04\x01\x01\x00\x00\x00\x00\x01\x00\x00\x00\x08\x00\x00\x00" +
+		"\x00\x01g\x00\x00\x00\x00\x00"
+
+	msgs, errs := DecodeAll([]byte(helloFrame + bad + helloFrame))
+	if len(msgs) != 2 || len(errs) != 1 {
+		t.Fatalf("got %d messages and errors %v, want 2 messages and 1 error", len(msgs), errs)
+	}
+	for i, msg := range msgs {
+		if msg.Member != "Hello" {
+			t.Errorf("message #%d: got member %q", i, msg.Member)
+		}
+	}
+	if errs[0] != (errDecodeAt{Offset: len(helloFrame), E: errHeaderFieldID(0)}) {
+		t.Errorf("got error %v", errs[0])
+	}
+
+	// A truncated message stops decoding.
+	msgs, errs = DecodeAll([]byte(helloFrame + helloFrame[:10]))
+	if len(msgs) != 1 || len(errs) != 1 {
+		t.Errorf("got %d messages and errors %v for truncated input", len(msgs), errs)
+	}
+}
+
+func TestUnmarshalBodyLength(t *testing.T) {
+	// A signal with a 'u' body.
+	const header = "l\x04\x01\x01\x0a\x00\x00\x00\x01\x00\x00\x00\x07\x00\x00\x00" +
+		"\x08\x01g\x00\x01u\x00\x00"
+	_, err := unmarshal([]byte(header + "\x01\x00\x00\x00"))
+	if err != (errBodyLength{Declared: 10, Actual: 4}) {
+		t.Errorf("got error %v, want errBodyLength", err)
+	}
+	// Fix the declared length.
+	data := []byte(header + "\x01\x00\x00\x00")
+	data[4] = 4
+	msg, err := unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Params) != 1 || msg.Params[0] != uint32(1) {
+		t.Errorf("got %v", msg.Params)
+	}
+}
+
+func TestUnmarshalBigEndian(t *testing.T) {
+	// A signal with a 'u' body of 1.
+	const data = "B\x04\x00\x01\x00\x00\x00\x04\x00\x00\x00\x01\x00\x00\x00\x37" +
+		"\x01\x01o\x00\x00\x00\x00\x02/a\x00\x00\x00\x00\x00\x00" +
+		"\x02\x01s\x00\x00\x00\x00\x03a.b\x00\x00\x00\x00\x00" +
+		"\x03\x01s\x00\x00\x00\x00\x01M\x00\x00\x00\x00\x00\x00\x00" +
+		"\x08\x01g\x00\x01u\x00\x00" +
+		"\x00\x00\x00\x01"
+	msg, err := unmarshal([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Path != "/a" || msg.Iface != "a.b" || msg.Member != "M" {
+		t.Errorf("header not decoded: %+v", msg)
+	}
+	if !reflect.DeepEqual(msg.Params, []interface{}{uint32(1)}) {
+		t.Errorf("got %#v, want [1]", msg.Params)
+	}
+}
+
+func TestUnmarshalEmptyBody(t *testing.T) {
+	// A method return declaring signature 's' without body.
+	const data = "l\x02\x01\x01\x00\x00\x00\x00\x01\x00\x00\x00\x0f\x00\x00\x00" +
+		"\x05\x01u\x00\x07\x00\x00\x00\x08\x01g\x00\x01s\x00\x00"
+	msg, err := unmarshal([]byte(data))
+	if err != (errEmptyBody{Sig: "s"}) {
+		t.Errorf("got error %v, want errEmptyBody", err)
+	}
+	const want = `message body is empty but signature is "s"`
+	if err != nil && err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+	if msg == nil || msg.replySerial != 7 {
+		t.Errorf("header not decoded: %+v", msg)
+	}
+}
+
+func TestUnmarshalBodySize(t *testing.T) {
+	msg := newTestSignal("org.example", "Changed", "")
+
+	// A body longer than its signature requires.
+	msg.SetRawBody("s", []byte("\x03\x00\x00\x00abc\x00\x01\x00\x00\x00"))
+	data, err := msg._Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = unmarshal(data)
+	if err != (errTrailingBody{Sig: "s", Extra: 4}) {
+		t.Errorf("got error %v, want errTrailingBody", err)
+	}
+
+	// Trailing padding is allowed.
+	msg.SetRawBody("s", []byte("\x03\x00\x00\x00abc\x00\x00\x00"))
+	data, _ = msg._Marshal()
+	if _, err = unmarshal(data); err != nil {
+		t.Errorf("padded body: %s", err)
+	}
+
+	// A body shorter than its signature requires.
+	msg.SetRawBody("su", []byte("\x03\x00\x00\x00abc\x00"))
+	data, _ = msg._Marshal()
+	_, err = unmarshal(data)
+	if _, ok := err.(errShortBody); !ok {
+		t.Errorf("got error %v, want errShortBody", err)
+	}
+}
+
+func TestDecodeErrorTypes(t *testing.T) {
+	msg := newTestSignal("org.example", "Changed", "")
+	msg.SetRawBody("su", []byte("\x03\x00\x00\x00abc\x00"))
+	data, err := msg._Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
 
+	// Truncated body.
+	var oor *errOutOfRange
+	if _, err = unmarshal(data); !errors.As(err, &oor) {
+		t.Errorf("truncated body: got error %v, want errOutOfRange", err)
+	}
+	// Truncated header.
+	if _, err = newRawMessage(data[:24]); !errors.As(err, &oor) {
+		t.Errorf("truncated header: got error %v, want errOutOfRange", err)
+	}
+
+	// Unknown type.
+	var sigErr errSignature
+	if _, _, err = Parse(data, "uz", 0); !errors.As(err, &sigErr) {
+		t.Errorf("got error %v, want errSignature", err)
+	}
+	var s string
+	var u uint32
+	m, _ := newRawMessage(data)
+	if err = m.Unmarshal(&s, &u); !errors.As(err, &oor) {
+		t.Errorf("Unmarshal: got error %v, want errOutOfRange", err)
+	}
+}
+
+func TestResults(t *testing.T) {
+	call := NewCall("org.example", "/org/example", "org.example", "Get")
+	reply := func(sig string, params ...interface{}) *Message {
+		r := newTestReply(call, sig, params...)
+		data, err := r._Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err = unmarshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	if s, err := reply("s", "hello").StringResult(); err != nil || s != "hello" {
+		t.Errorf("StringResult: got %q, %v", s, err)
+	}
+	if u, err := reply("u", uint32(42)).Uint32Result(); err != nil || u != 42 {
+		t.Errorf("Uint32Result: got %d, %v", u, err)
+	}
+	if b, err := reply("b", true).BoolResult(); err != nil || !b {
+		t.Errorf("BoolResult: got %v, %v", b, err)
+	}
+
+	// Wrong types and arity.
+	if _, err := reply("u", uint32(42)).StringResult(); err != (errResultSig{Sig: "u", Want: "s"}) {
+		t.Errorf("StringResult: got error %v", err)
+	}
+	if _, err := reply("s", "hello").Uint32Result(); err != (errResultSig{Sig: "s", Want: "u"}) {
+		t.Errorf("Uint32Result: got error %v", err)
+	}
+	if _, err := reply("bb", true, false).BoolResult(); err != (errResultSig{Sig: "bb", Want: "b"}) {
+		t.Errorf("BoolResult: got error %v", err)
+	}
+	if _, err := reply("").BoolResult(); err != (errResultSig{Sig: "", Want: "b"}) {
+		t.Errorf("BoolResult: got error %v", err)
+	}
+}
+
+func TestUnmarshalNumFds(t *testing.T) {
+	// The header declares a file descriptor that the body
+	// does not reference.
+	msg := newTestSignal("org.example", "Changed", "u", uint32(0))
+	msg.Fds = []int{0}
+	data, err := msg._Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = unmarshal(data)
+	if err != (errNumFds{Declared: 1, Received: 0}) {
+		t.Errorf("got error %v, want errNumFds", err)
+	}
+
+	// Several values may refer to the same file descriptor.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	msg.Sig = "hh"
+	msg.Params = []interface{}{uint32(0), uint32(0)}
+	msg.Fds = []int{int(r.Fd())}
+	if data, err = msg._Marshal(); err != nil {
+		t.Fatal(err)
+	}
+	dec, err := newRawMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := syscall.Dup(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec.Fds = []int{fd}
+	if err = dec.parseParams(); err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Params[0].(*os.File).Close()
+	if dec.Params[0] != dec.Params[1] {
+		t.Error("values referring to the same file descriptor decoded to different files")
+	}
+
+	// An index past UNIX_FDS.
+	msg.Params = []interface{}{uint32(0), uint32(1)}
+	if data, err = msg._Marshal(); err != nil {
+		t.Fatal(err)
+	}
+	dec, _ = newRawMessage(data)
+	dec.Fds = []int{-1}
+	if err = dec.parseParams(); err == nil {
+		t.Error("no error for a file descriptor index past UNIX_FDS")
+	}
+}
+
+func TestMarshal(t *testing.T) {
 	msg := NewMessage()
 	msg.Type = TypeMethodCall
 	msg.Flags = MessageFlag(0)
@@ -40,8 +330,8 @@ func TestMarshal(t *testing.T) {
 	msg.serial = 1
 
 	buff, _ := msg._Marshal()
-	if teststr != string(buff) {
-		t.Errorf("got\n%q\nwant\n%q", buff, teststr)
+	if helloFrame != string(buff) {
+		t.Errorf("got\n%q\nwant\n%q", buff, helloFrame)
 	}
 }
 
@@ -66,9 +356,7 @@ func BenchmarkMessage_Marshal(b *testing.B) {
 }
 
 func BenchmarkMessage_Unmarshal(b *testing.B) {
-	const teststr = "l\x01\x00\x01\x00\x00\x00\x00\x01\x00\x00\x00m\x00\x00\x00\x01\x01o\x00\x15\x00\x00\x00/org/freedesktop/DBus\x00\x00\x00\x02\x01s\x00\x14\x00\x00\x00org.freedesktop.DBus\x00\x00\x00\x00\x03\x01s\x00\x05\x00\x00\x00Hello\x00\x00\x00\x06\x01s\x00\x14\x00\x00\x00org.freedesktop.DBus\x00\x00\x00\x00"
-
-	input := []byte(teststr)
+	input := []byte(helloFrame)
 	for i := 0; i < b.N; i++ {
 		msg, err := unmarshal(input)
 		if err != nil {
@@ -76,7 +364,7 @@ func BenchmarkMessage_Unmarshal(b *testing.B) {
 		}
 		_ = msg
 	}
-	b.SetBytes(int64(len(teststr)))
+	b.SetBytes(int64(len(helloFrame)))
 }
 
 const test_as = "\xa9\x02\x00\x00\x14\x00\x00\x00org.freedesktop.DBus\x00\x00\x00\x00\x05\x00\x00\x00:1.92\x00\x00\x00\x04\x00\x00\x00:1.7\x00\x00\x00\x00\x0e\x00\x00\x00org.xfce.Panel\x00\x00\x04\x00\x00\x00:1.8\x00\x00\x00\x00\x04\x00\x00\x00:1.9\x00\x00\x00\x00\x0f\x00\x00\x00org.xfce.Thunar\x00\x12\x00\x00\x00org.xfce.Appfinder\x00\x00\x0f\x00\x00\x00org.gnome.GConf\x00$\x00\x00\x00org.gtk.Private.UDisks2VolumeMonitor\x00\x00\x00\x00\f\x00\x00\x00org.a11y.Bus\x00\x00\x00\x00\x05\x00\x00\x00:1.10\x00\x00\x00\x0f\x00\x00\x00org.xfce.Xfconf\x00\x05\x00\x00\x00:1.55\x00\x00\x00\x05\x00\x00\x00:1.11\x00\x00\x00\x05\x00\x00\x00:1.12\x00\x00\x00\x05\x00\x00\x00:1.24\x00\x00\x00\x04\x00\x00\x00:1.0\x00\x00\x00\x00\x12\x00\x00\x00org.gtk.vfs.Daemon\x00\x00\x06\x00\x00\x00:1.133\x00\x00\x06\x00\x00\x00:1.122\x00\x00\x05\x00\x00\x00:1.25\x00\x00\x00\x05\x00\x00\x00:1.14\x00\x00\x00\x04\x00\x00\x00:1.1\x00\x00\x00\x00\x06\x00\x00\x00:1.123\x00\x00\x05\x00\x00\x00:1.37\x00\x00\x00\x05\x00\x00\x00:1.15\x00\x00\x00\x17\x00\x00\x00org.xfce.SettingsDaemon\x00\x17\x00\x00\x00org.xfce.SessionManager\x00\x04\x00\x00\x00:1.2\x00\x00\x00\x00\x06\x00\x00\x00:1.124\x00\x00\x05\x00\x00\x00:1.16\x00\x00\x00\x04\x00\x00\x00:1.3\x00\x00\x00\x00\x05\x00\x00\x00:1.28\x00\x00\x00\x05\x00\x00\x00:1.17\x00\x00\x00\x14\x00\x00\x00org.xfce.FileManager\x00\x00\x00\x00\x04\x00\x00\x00:1.4\x00\x00\x00\x00\x0e\x00\x00\x00ca.desrt.dconf\x00\x00\x05\x00\x00\x00:1.18\x00\x00\x00\x04\x00\x00\x00:1.5\x00\x00\x00\x00\x05\x00\x00\x00:1.91\x00\x00\x00\x05\x00\x00\x00:1.19\x00\x00\x00\x04\x00\x00\x00:1.6\x00"
@@ -108,3 +396,178 @@ func BenchmarkMessage_UnmarshalReflect1(b *testing.B) {
 	}
 	b.SetBytes(int64(len(testMsg2)))
 }
+
+func TestForwardRawBody(t *testing.T) {
+	orig := newTestSignal("org.example", "Changed", "sa{ss}u",
+		"name",
+		[]interface{}{[]interface{}{"k", "v"}},
+		uint32(42))
+	data, err := orig._Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := NewMessage()
+	out.Type = in.Type
+	out.Path = in.Path
+	out.Iface = in.Iface
+	out.Member = in.Member
+	out.serial = in.serial
+	out.SetRawBody(in.Sig, in.Body())
+	forwarded, err := out._Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(forwarded, data) {
+		t.Errorf("got\n%q\nwant\n%q", forwarded, data)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	bodies := []struct {
+		sig    string
+		params []interface{}
+	}{
+		{"as", []interface{}{[]interface{}{"a", "bc"}}},
+		{"a{sv}", []interface{}{[]interface{}{
+			[]interface{}{"Name", "x"},
+			[]interface{}{"Size", uint32(1)},
+			[]interface{}{"Tags", []interface{}{"t1", "t2"}},
+		}}},
+		{"(su)", []interface{}{[]interface{}{"x", uint32(7)}}},
+	}
+	for _, body := range bodies {
+		orig := newTestSignal("org.example", "Changed", body.sig, body.params...)
+		data, err := orig._Marshal()
+		if err != nil {
+			t.Fatalf("%s: %s", body.sig, err)
+		}
+		in, err := unmarshal(data)
+		if err != nil {
+			t.Fatalf("%s: %s", body.sig, err)
+		}
+		again, err := in._Marshal()
+		if err != nil {
+			t.Errorf("%s: marshalling decoded message: %s", body.sig, err)
+			continue
+		}
+		if !bytes.Equal(again, data) {
+			t.Errorf("%s: got\n%q\nwant\n%q", body.sig, again, data)
+		}
+	}
+}
+
+func TestMarshalMap(t *testing.T) {
+	// A Go map, as a dictionary and inside a variant.
+	orig := newTestSignal("org.example", "Changed", "a{su}v",
+		map[string]uint32{"a": 1},
+		map[string]uint32{"b": 2})
+	data, err := orig._Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{
+		[]interface{}{[]interface{}{"a", uint32(1)}},
+		[]interface{}{[]interface{}{"b", uint32(2)}},
+	}
+	if !reflect.DeepEqual(in.Params, want) {
+		t.Errorf("got %#v, want %#v", in.Params, want)
+	}
+}
+
+func TestRetain(t *testing.T) {
+	orig := newTestSignal("org.example", "Changed", "s", "hello")
+	data, err := orig._Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Retain()
+	// Reuse the buffer.
+	copy(data[len(data)-6:], "HELLO")
+	var s string
+	if err := msg.Unmarshal(&s); err != nil {
+		t.Fatal(err)
+	}
+	if s != "hello" {
+		t.Errorf("got %q after reusing the buffer, want hello", s)
+	}
+}
+
+func TestMarshalArity(t *testing.T) {
+	for _, params := range [][]interface{}{
+		{"one"},
+		{"one", uint32(2), "three"},
+	} {
+		msg := newTestSignal("org.example", "Changed", "su", params...)
+		_, err := msg._Marshal()
+		if err != (errArity{Sig: "su", Values: len(params)}) {
+			t.Errorf("%d arguments: got error %v, want errArity", len(params), err)
+		}
+	}
+}
+
+func TestNewCall(t *testing.T) {
+	want := NewMessage()
+	want.Type = TypeMethodCall
+	want.Flags = MessageFlag(0)
+	want.Path = "/org/freedesktop/DBus"
+	want.Dest = "org.freedesktop.DBus"
+	want.Iface = "org.freedesktop.DBus"
+	want.Member = "Hello"
+
+	msg := NewCall("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello")
+	if msg.serial == want.serial {
+		t.Errorf("messages share serial %d", msg.serial)
+	}
+	msg.serial = want.serial
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("got %+v, want %+v", msg, want)
+	}
+}
+
+func TestMarshalledSize(t *testing.T) {
+	type pair struct {
+		Key   string
+		Value uint64
+	}
+	hello := NewCall("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello")
+	signal := newTestSignal("org.example", "Changed", "sa{ss}avy",
+		"name",
+		[]interface{}{[]interface{}{"k", "v"}},
+		[]interface{}{uint32(1), "two", []string{"three"}},
+		byte(4))
+	reflected := NewCall("org.example", "/org/example", "org.example", "Set")
+	reflected.Sig = "ya(st)"
+	reflected.Params = []interface{}{byte(1), []pair{{"a", 1}, {"bc", 2}}}
+	reflected.reflect = true
+	raw := NewMessage()
+	raw.Type = TypeMethodReturn
+	raw.replySerial = 7
+	raw.SetRawBody("u", []byte{1, 0, 0, 0})
+
+	for i, msg := range []*Message{hello, signal, reflected, raw} {
+		data, err := msg._Marshal()
+		if err != nil {
+			t.Fatalf("#%d: %s", i, err)
+		}
+		size, err := msg.MarshalledSize()
+		if err != nil {
+			t.Fatalf("#%d: %s", i, err)
+		}
+		if size != len(data) {
+			t.Errorf("#%d: MarshalledSize() = %d, marshalled %d bytes", i, size, len(data))
+		}
+	}
+}
@@ -0,0 +1,34 @@
+//go:build linux
+
+package dbus
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// PeerCredentials returns the credentials of the process at the other
+// end of the connection, as reported by the kernel (SO_PEERCRED).
+// It requires a unix socket connection.
+func (p *Connection) PeerCredentials() (uid, gid, pid uint32, err error) {
+	conn, ok := p.conn.(*net.UnixConn)
+	if !ok {
+		return 0, 0, 0, errors.New("peer credentials require a unix socket")
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	var cred *syscall.Ucred
+	cerr := raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if cerr != nil {
+		return 0, 0, 0, cerr
+	}
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return cred.Uid, cred.Gid, uint32(cred.Pid), nil
+}
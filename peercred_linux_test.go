@@ -0,0 +1,24 @@
+//go:build linux
+
+package dbus
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPeerCredentials(t *testing.T) {
+	cli, srv := newSocketPair(t)
+	defer srv.Close()
+	conn := &Connection{conn: cli}
+	defer cli.Close()
+
+	uid, gid, pid, err := conn.PeerCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid != uint32(os.Getuid()) || gid != uint32(os.Getgid()) || pid != uint32(os.Getpid()) {
+		t.Errorf("got uid=%d gid=%d pid=%d, want uid=%d gid=%d pid=%d",
+			uid, gid, pid, os.Getuid(), os.Getgid(), os.Getpid())
+	}
+}
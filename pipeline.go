@@ -0,0 +1,72 @@
+package dbus
+
+import "time"
+
+// A Pipeline is a batch of method calls sent to the bus in
+// a single write. Replies are matched to calls by serial number.
+type Pipeline struct {
+	conn     *Connection
+	calls    []*Message
+	timeouts []time.Duration
+}
+
+// Pipeline returns an empty batch of method calls.
+func (p *Connection) Pipeline() *Pipeline {
+	return &Pipeline{conn: p}
+}
+
+// Add appends a method call with the given arguments to the batch.
+func (b *Pipeline) Add(method *Method, args ...interface{}) {
+	b.calls = append(b.calls, newCall(method, args, false))
+	b.timeouts = append(b.timeouts, b.conn.timeout(method.iface.obj))
+}
+
+// Do sends all the method calls of the batch and waits for their
+// replies. The output arguments of the i-th call are stored at
+// index i of the result. The first error reply, if any, is returned
+// after all replies have been received. Each reply is awaited at most
+// the call timeout of its object, after which the call fails.
+func (b *Pipeline) Do() ([][]interface{}, error) {
+	p := b.conn
+	// The batch is emptied, whether it is sent or not.
+	calls, timeouts := b.calls, b.timeouts
+	b.calls, b.timeouts = nil, nil
+	var buf []byte
+	for _, msg := range calls {
+		rawmsg, err := p.marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, rawmsg...)
+	}
+
+	replyChans := make([]<-chan *Message, len(calls))
+	for i, msg := range calls {
+		// Replies may arrive in any order: buffer them.
+		replyChans[i] = p.expectReply(msg.serial, 1)
+	}
+	if _, err := p.conn.Write(buf); err != nil {
+		for _, msg := range calls {
+			p.forgetReply(msg.serial)
+		}
+		// kill connection.
+		p.conn.Close()
+		return nil, err
+	}
+
+	var firstErr error
+	out := make([][]interface{}, len(replyChans))
+	for i, ch := range replyChans {
+		reply, err := p.waitReply(calls[i].serial, ch, timeouts[i])
+		if err == nil && reply.Type == TypeError {
+			err = newDBusError(reply)
+		} else if err == nil {
+			err = reply.parseParams()
+			out[i] = reply.Params
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return out, firstErr
+}
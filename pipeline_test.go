@@ -0,0 +1,157 @@
+package dbus
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func newEchoMethod(t testing.TB) *Method {
+	return newTestMethod(t, `<node><interface name="org.example.Echo">
+		<method name="Echo">
+		  <arg direction="in" type="s"/>
+		  <arg direction="out" type="s"/>
+		</method>
+		</interface></node>`, "org.example.Echo", "Echo")
+}
+
+func newEchoConnection() *Connection {
+	return newTestConnection(func(msg *Message) *Message {
+		if msg.Params[0] == "slow" {
+			// Never answer.
+			return nil
+		}
+		if msg.Params[0] == "fail" {
+			return newTestError(msg, "org.example.Error", "failed")
+		}
+		return newTestReply(msg, "s", msg.Params[0])
+	})
+}
+
+func TestPipeline(t *testing.T) {
+	conn := newEchoConnection()
+	method := newEchoMethod(t)
+	batch := conn.Pipeline()
+	for i := 0; i < 5; i++ {
+		batch.Add(method, fmt.Sprint("call", i))
+	}
+	out, err := batch.Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 5 {
+		t.Fatalf("got %d replies, want 5", len(out))
+	}
+	for i, res := range out {
+		if want := fmt.Sprint("call", i); len(res) != 1 || res[0] != want {
+			t.Errorf("reply %d: got %v, want [%s]", i, res, want)
+		}
+	}
+
+	batch.Add(method, "ok")
+	batch.Add(method, "fail")
+	out, err = batch.Do()
+	if _, ok := err.(*DBusError); !ok {
+		t.Errorf("got error %v, want a *DBusError", err)
+	}
+	if len(out) != 2 || len(out[0]) != 1 || out[0][0] != "ok" || out[1] != nil {
+		t.Errorf("got %v", out)
+	}
+}
+
+func TestPipelineTimeout(t *testing.T) {
+	conn := newEchoConnection()
+	conn.SetCallTimeout(5 * time.Second)
+	method := newEchoMethod(t)
+	method.iface.obj.SetTimeout(20 * time.Millisecond)
+	batch := conn.Pipeline()
+	batch.Add(method, "slow")
+	batch.Add(method, "ok")
+
+	start := time.Now()
+	out, err := batch.Do()
+	if err != errCallTimeout {
+		t.Fatalf("got error %v, want %v", err, errCallTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("batch timed out after %s, want the object timeout", elapsed)
+	}
+	if len(out) != 2 || out[0] != nil || len(out[1]) != 1 || out[1][0] != "ok" {
+		t.Errorf("got %v", out)
+	}
+	conn.replyLock.Lock()
+	pending := len(conn.replyChans)
+	conn.replyLock.Unlock()
+	if pending != 0 {
+		t.Errorf("%d reply channels left after timeout", pending)
+	}
+}
+
+func TestPipelineMarshalError(t *testing.T) {
+	conn := newEchoConnection()
+	method := newEchoMethod(t)
+	batch := conn.Pipeline()
+	batch.Add(method, "hello")
+	batch.Add(method, 42)
+	if _, err := batch.Do(); err == nil {
+		t.Fatal("expected an error for an int argument")
+	}
+	// The failed calls are not sent again.
+	batch.Add(method, "world")
+	out, err := batch.Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || len(out[0]) != 1 || out[0][0] != "world" {
+		t.Errorf("got %v, want [[world]]", out)
+	}
+}
+
+func TestPipelineWriteError(t *testing.T) {
+	cli, srv := net.Pipe()
+	srv.Close()
+	conn := &Connection{conn: cli}
+	conn.init()
+	method := newEchoMethod(t)
+	batch := conn.Pipeline()
+	batch.Add(method, "hello")
+	batch.Add(method, "world")
+	if _, err := batch.Do(); err == nil {
+		t.Fatal("expected a write error")
+	}
+	conn.replyLock.Lock()
+	pending := len(conn.replyChans)
+	conn.replyLock.Unlock()
+	if pending != 0 {
+		t.Errorf("%d reply channels left after write error", pending)
+	}
+}
+
+const benchBatchSize = 16
+
+func BenchmarkSequentialCalls(b *testing.B) {
+	conn := newEchoConnection()
+	method := newEchoMethod(b)
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchBatchSize; j++ {
+			if _, err := conn.Call(method, "hello"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkPipelinedCalls(b *testing.B) {
+	conn := newEchoConnection()
+	method := newEchoMethod(b)
+	for i := 0; i < b.N; i++ {
+		batch := conn.Pipeline()
+		for j := 0; j < benchBatchSize; j++ {
+			batch.Add(method, "hello")
+		}
+		if _, err := batch.Do(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package dbus
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// UnixMicros converts a timestamp expressed in microseconds since
+// the Unix epoch, as returned by many systemd interfaces with type
+// 't', to a time.Time.
+func UnixMicros(v interface{}) (time.Time, error) {
+	switch v := v.(type) {
+	case uint64:
+		return time.UnixMicro(int64(v)), nil
+	case int64:
+		return time.UnixMicro(v), nil
+	}
+	return time.Time{}, fmt.Errorf("cannot convert %T to a timestamp", v)
+}
+
+// MicrosFromTime converts t to a number of microseconds since the Unix
+// epoch, suitable as a 't' argument. It is the inverse of UnixMicros.
+func MicrosFromTime(t time.Time) uint64 {
+	return uint64(t.UnixMicro())
+}
@@ -0,0 +1,49 @@
+package dbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestUnixMicros(t *testing.T) {
+	const micros = uint64(1700000000123456)
+	want := time.Date(2023, time.November, 14, 22, 13, 20, 123456000, time.UTC)
+	got, err := UnixMicros(micros)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(want) {
+		t.Errorf("UnixMicros(%d) = %s, want %s", micros, got, want)
+	}
+	if back := MicrosFromTime(got); back != micros {
+		t.Errorf("MicrosFromTime(%s) = %d, want %d", got, back, micros)
+	}
+	if _, err := UnixMicros("yesterday"); err == nil {
+		t.Error("expected an error converting a string")
+	}
+}
+
+func TestAppendTime(t *testing.T) {
+	ts := time.Date(2023, time.November, 14, 22, 13, 20, 123456000, time.UTC)
+	fromTime := &msgData{ByteOrder: binary.LittleEndian}
+	if err := appendValue(fromTime, mustParseSig("t"), ts); err != nil {
+		t.Fatal(err)
+	}
+	fromInt := &msgData{ByteOrder: binary.LittleEndian}
+	if err := appendValue(fromInt, mustParseSig("t"), uint64(1700000000123456)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fromTime.Data, fromInt.Data) {
+		t.Errorf("time.Time encoded as %x, want %x", fromTime.Data, fromInt.Data)
+	}
+
+	put := &msgData{ByteOrder: binary.LittleEndian}
+	if err := put.put("t", ts); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(put.Data, fromInt.Data) {
+		t.Errorf("time.Time put as %x, want %x", put.Data, fromInt.Data)
+	}
+}
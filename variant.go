@@ -0,0 +1,331 @@
+package dbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+)
+
+// A Variant is a value of type 'v' along with its signature.
+// Values of other Go types passed as variants are marshalled
+// with the signature given by SignatureOf.
+type Variant struct {
+	Sig   Signature
+	Value interface{}
+}
+
+var (
+	variantType    = reflect.TypeOf(Variant{})
+	objectPathType = reflect.TypeOf(ObjectPath(""))
+)
+
+// SignatureOf returns the D-Bus signature of the type of v: slices
+// are arrays, maps are dictionaries, structs are structures, and
+// interface values are variants. A time.Time is a uint64 timestamp
+// in microseconds.
+func SignatureOf(v interface{}) (Signature, error) {
+	if v == nil {
+		return "", fmt.Errorf("no signature for nil value")
+	}
+	sig, err := signatureOfType(reflect.TypeOf(v))
+	return Signature(sig), err
+}
+
+func signatureOfType(t reflect.Type) (string, error) {
+	switch t {
+	case signatureType:
+		return "g", nil
+	case objectPathType:
+		return "o", nil
+	case variantType:
+		return "v", nil
+	case timeType:
+		return "t", nil
+	case fileType:
+		return "h", nil
+	}
+	switch t.Kind() {
+	case reflect.Uint8:
+		return "y", nil
+	case reflect.Bool:
+		return "b", nil
+	case reflect.Int16:
+		return "n", nil
+	case reflect.Uint16:
+		return "q", nil
+	case reflect.Int32:
+		return "i", nil
+	case reflect.Uint32:
+		return "u", nil
+	case reflect.Int64:
+		return "x", nil
+	case reflect.Uint64:
+		return "t", nil
+	case reflect.Float64:
+		return "d", nil
+	case reflect.String:
+		return "s", nil
+	case reflect.Interface:
+		return "v", nil
+	case reflect.Slice, reflect.Array:
+		elem, err := signatureOfType(t.Elem())
+		return "a" + elem, err
+	case reflect.Map:
+		key, err := signatureOfType(t.Key())
+		if err != nil {
+			return "", err
+		}
+		value, err := signatureOfType(t.Elem())
+		return "a{" + key + value + "}", err
+	case reflect.Struct:
+		sig := "("
+		for i := 0; i < t.NumField(); i++ {
+			fld, err := signatureOfType(t.Field(i).Type)
+			if err != nil {
+				return "", err
+			}
+			sig += fld
+		}
+		if sig == "(" {
+			return "", errEmptyStruct
+		}
+		return sig + ")", nil
+	case reflect.Ptr:
+		return signatureOfType(t.Elem())
+	}
+	return "", fmt.Errorf("no D-Bus type for %s", t)
+}
+
+// typeOfSignature returns the Go type a value of type sig naturally
+// decodes to: basic types, and arrays and dictionaries of them. It
+// returns nil for other types, which keep the generic representation.
+func typeOfSignature(sig signature) reflect.Type {
+	switch sig := sig.(type) {
+	case basicSig:
+		switch sig {
+		case 'y':
+			return reflect.TypeOf(byte(0))
+		case 'b':
+			return reflect.TypeOf(false)
+		case 'n':
+			return reflect.TypeOf(int16(0))
+		case 'q':
+			return reflect.TypeOf(uint16(0))
+		case 'i':
+			return reflect.TypeOf(int32(0))
+		case 'u':
+			return reflect.TypeOf(uint32(0))
+		case 'x':
+			return reflect.TypeOf(int64(0))
+		case 't':
+			return reflect.TypeOf(uint64(0))
+		case 'd':
+			return reflect.TypeOf(float64(0))
+		case 's', 'g':
+			return reflect.TypeOf("")
+		case 'o':
+			return objectPathType
+		}
+	case arraySig:
+		if elem := typeOfSignature(sig.Elem); elem != nil {
+			return reflect.SliceOf(elem)
+		}
+	case dictSig:
+		key, value := typeOfSignature(sig.Key), typeOfSignature(sig.Value)
+		if key != nil && value != nil {
+			return reflect.MapOf(key, value)
+		}
+	}
+	return nil
+}
+
+// scanVariant decodes a variant to a value of the type given by
+// typeOfSignature, or to the representation used by Call for
+// other types.
+func (msg *msgData) scanVariant() (val interface{}, err error) {
+	defer catchPanicErr(&err)
+	l := msg.Next(1)[0]
+	s := msg.Next(int(l) + 1)
+	sig, rest, err := parseOneSignature(string(s[:l]))
+	if err == nil && rest != "" {
+		err = errVariantSig(s[:l])
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t := typeOfSignature(sig); t != nil {
+		v := reflect.New(t).Elem()
+		if err = msg.scanValue(sig, v); err != nil {
+			return nil, err
+		}
+		return v.Interface(), nil
+	}
+	vals, err := parseVariants(msg, []signature{sig})
+	if err != nil {
+		return nil, err
+	}
+	return vals[0], nil
+}
+
+// checkValue verifies that val can be marshalled as type sig,
+// in the representation used by Call.
+func checkValue(sig signature, val interface{}) error {
+	vals, generic := val.([]interface{})
+	switch sig := sig.(type) {
+	case arraySig:
+		if generic {
+			for _, v := range vals {
+				if err := checkValue(sig.Elem, v); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case dictSig:
+		if generic {
+			for _, v := range vals {
+				entry, ok := v.([]interface{})
+				if !ok || len(entry) != 2 {
+					return fmt.Errorf("dict entry %#v is not a key/value pair", v)
+				}
+				if err := checkValue(sig.Key, entry[0]); err != nil {
+					return err
+				}
+				if err := checkValue(sig.Value, entry[1]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case structSig:
+		if generic {
+			if len(vals) != len(sig) {
+				return fmt.Errorf("got %d values for structure %s", len(vals), sig)
+			}
+			for i, fldsig := range sig {
+				if err := checkValue(fldsig, vals[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case basicSig:
+		switch sig {
+		case 'v':
+			return nil
+		case 's', 'o', 'g':
+			if val != nil && reflect.TypeOf(val).Kind() == reflect.String {
+				return nil
+			}
+		}
+	}
+	if s, err := SignatureOf(val); err != nil || string(s) != sig.String() {
+		return fmt.Errorf("cannot use %T as %s", val, sig)
+	}
+	return nil
+}
+
+// appendVariant marshals val as a variant: its signature followed
+// by the value.
+func appendVariant(msg *msgData, val interface{}) error {
+	v, ok := val.(Variant)
+	if !ok {
+		sig, err := variantSignature(val)
+		if err != nil {
+			return err
+		}
+		v = Variant{Sig: sig, Value: val}
+	}
+	sig, rest, err := parseOneSignature(string(v.Sig))
+	if err == nil && rest != "" {
+		err = errVariantSig(v.Sig)
+	}
+	if err != nil {
+		return err
+	}
+	msg.Put([]byte{byte(len(v.Sig))})
+	msg.PutString(string(v.Sig))
+	msg.Put([]byte{0})
+	return appendValue(msg, sig, v.Value)
+}
+
+// variantSignature returns the signature of val as a variant. Generic
+// arrays, as decoded by Parse, are arrays of the common type of their
+// elements, or arrays of variants if it differs, so that a decoded
+// variant is marshalled again with its original signature.
+func variantSignature(val interface{}) (Signature, error) {
+	vals, ok := val.([]interface{})
+	if !ok || len(vals) == 0 {
+		return SignatureOf(val)
+	}
+	elem, err := variantSignature(vals[0])
+	if err != nil {
+		return "", err
+	}
+	for _, v := range vals[1:] {
+		if sig, err := variantSignature(v); err != nil || sig != elem {
+			return "av", nil
+		}
+	}
+	return "a" + elem, nil
+}
+
+type errVariantType struct {
+	Sig  Signature
+	Type reflect.Type
+}
+
+func (e errVariantType) Error() string {
+	return fmt.Sprintf("cannot store variant of type %q in %s", string(e.Sig), e.Type)
+}
+
+// As stores the value of the variant in the value pointed to by dest.
+// Values decoded in the generic representation are converted to the
+// type of dest, so that for example an array of strings decoded as
+// []interface{} can be stored in a []string, and a dictionary in a
+// map[string]interface{}.
+func (v Variant) As(dest interface{}) error {
+	d := reflect.ValueOf(dest)
+	if d.Kind() != reflect.Ptr || d.IsNil() {
+		return fmt.Errorf("cannot store variant in %T", dest)
+	}
+	d = d.Elem()
+	val := reflect.ValueOf(v.Value)
+	if val.IsValid() && val.Type().AssignableTo(d.Type()) {
+		d.Set(val)
+		return nil
+	}
+	s := v.Sig
+	if s == "" {
+		var err error
+		if s, err = variantSignature(v.Value); err != nil {
+			return err
+		}
+	}
+	sig, rest, err := parseOneSignature(string(s))
+	if err == nil && rest != "" {
+		err = errVariantSig(s)
+	}
+	if err != nil {
+		return err
+	}
+	// Convert by marshalling and decoding to the type of dest.
+	msg := &msgData{ByteOrder: binary.LittleEndian}
+	if err = appendValue(msg, sig, v.Value); err != nil {
+		return fmt.Errorf("variant of type %q holds a %T: %w", string(s), v.Value, err)
+	}
+	msg.Idx = 0
+	out := reflect.New(d.Type()).Elem()
+	if err = msg.scanValue(sig, out); err != nil {
+		return errVariantType{Sig: s, Type: d.Type()}
+	}
+	d.Set(out)
+	return nil
+}
+
+func stringValue(val interface{}) string {
+	if v := reflect.ValueOf(val); v.Kind() == reflect.String {
+		return v.String()
+	}
+	panic(fmt.Errorf("expected a string, got %T", val))
+}
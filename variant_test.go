@@ -0,0 +1,107 @@
+package dbus
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSignatureOf(t *testing.T) {
+	type pair struct {
+		Name  string
+		Value uint32
+	}
+	tests := []struct {
+		v   interface{}
+		sig Signature
+	}{
+		{byte(1), "y"},
+		{true, "b"},
+		{int16(1), "n"},
+		{int32(1), "i"},
+		{uint64(1), "t"},
+		{1.5, "d"},
+		{"s", "s"},
+		{ObjectPath("/"), "o"},
+		{Signature("s"), "g"},
+		{time.Now(), "t"},
+		{[]string{"a"}, "as"},
+		{[]interface{}{"a"}, "av"},
+		{map[string]Variant{}, "a{sv}"},
+		{pair{}, "(su)"},
+		{[]pair{}, "a(su)"},
+	}
+	for _, test := range tests {
+		sig, err := SignatureOf(test.v)
+		if err != nil || sig != test.sig {
+			t.Errorf("SignatureOf(%#v) = %q, %v, want %q", test.v, sig, err, test.sig)
+		}
+	}
+	for _, v := range []interface{}{nil, 1, struct{}{}, make(chan int)} {
+		if sig, err := SignatureOf(v); err == nil {
+			t.Errorf("SignatureOf(%#v) = %q, expected an error", v, sig)
+		}
+	}
+}
+
+func TestVariantAs(t *testing.T) {
+	var s string
+	if err := (Variant{Sig: "s", Value: "hello"}).As(&s); err != nil || s != "hello" {
+		t.Errorf("got %q, %v", s, err)
+	}
+
+	// An array of strings, as decoded by Parse.
+	var ss []string
+	v := Variant{Sig: "as", Value: []interface{}{"a", "b"}}
+	if err := v.As(&ss); err != nil || !reflect.DeepEqual(ss, []string{"a", "b"}) {
+		t.Errorf("got %q, %v", ss, err)
+	}
+
+	// A dictionary, as decoded by Parse.
+	var m map[string]interface{}
+	v = Variant{Sig: "a{sv}", Value: []interface{}{
+		[]interface{}{"name", "x"},
+		[]interface{}{"size", uint32(3)},
+	}}
+	want := map[string]interface{}{"name": "x", "size": uint32(3)}
+	if err := v.As(&m); err != nil || !reflect.DeepEqual(m, want) {
+		t.Errorf("got %#v, %v", m, err)
+	}
+
+	var u uint32
+	if err := (Variant{Sig: "s", Value: "hello"}).As(&u); err != (errVariantType{Sig: "s", Type: reflect.TypeOf(u)}) {
+		t.Errorf("got error %v, want errVariantType", err)
+	}
+	// A value not matching the signature of the variant.
+	if err := (Variant{Sig: "u", Value: int32(1)}).As(&u); err == nil {
+		t.Error("expected an error for an int32 in a variant of type u")
+	}
+	if err := (Variant{Sig: "s", Value: "hello"}).As(s); err == nil {
+		t.Error("expected an error for a non-pointer destination")
+	}
+}
+
+func TestVariantArray(t *testing.T) {
+	values := []interface{}{
+		"hello",
+		uint32(42),
+		Variant{Sig: "as", Value: []string{"a", "b"}},
+	}
+	msg := &msgData{ByteOrder: binary.LittleEndian}
+	if err := appendValue(msg, mustParseSig("av"), values); err != nil {
+		t.Fatal(err)
+	}
+	out, _, err := Parse(msg.Data, "av", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{[]interface{}{
+		"hello",
+		uint32(42),
+		[]interface{}{"a", "b"},
+	}}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("got %#v, want %#v", out, want)
+	}
+}